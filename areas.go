@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// An area is a top-level slice of a monorepo that's tracked in its own JIRA project or component.
+type area struct {
+	Dir       string
+	Project   string
+	Component string
+	Files     []string
+}
+
+// parseAreaMap parses a list like "api=API,web=FE/Frontend" into areas.
+func parseAreaMap(s string) []area {
+	var areas []area
+	for _, pair := range strings.Split(s, ",") {
+		dir, target, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		project, component, _ := strings.Cut(strings.TrimSpace(target), "/")
+		areas = append(areas, area{Dir: strings.Trim(strings.TrimSpace(dir), "/"), Project: project, Component: component})
+	}
+	return areas
+}
+
+// touchedAreas returns the areas the commit changes files in, in map order.
+func touchedAreas(areas []area, commitInfo *commitInfo) ([]area, error) {
+	args := []string{"diff-tree", "--no-commit-id", "--name-only", "-r", commitInfo.Rev}
+	if commitInfo.MessageFile != "" {
+		args = []string{"diff", "--cached", "--name-only"}
+	}
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var touched []area
+	for _, a := range areas {
+		for _, file := range strings.Fields(string(out)) {
+			if strings.HasPrefix(file, a.Dir+"/") {
+				a.Files = append(a.Files, file)
+			}
+		}
+		if len(a.Files) > 0 {
+			touched = append(touched, a)
+		}
+	}
+	return touched, nil
+}
+
+// createAreaIssues files one ticket per area when the commit spans more than one, and links them together.
+// It returns no keys if the commit only touches one area or the user declines, in which case we fall back
+// to a single ticket. The extra keys are kept in commitInfo.RelatedKeys so the PR references all of them.
+func createAreaIssues(ctx context.Context, jiraClient *jira.Client, commitInfo *commitInfo) ([]string, error) {
+	touched, err := touchedAreas(parseAreaMap(jiraAreaMap), commitInfo)
+	if err != nil || len(touched) < 2 {
+		return nil, err
+	}
+	var dirs []string
+	for _, a := range touched {
+		dirs = append(dirs, a.Dir)
+	}
+	if !*assumeYes && !*dryRun && !confirm(fmt.Sprintf("This commit touches %s. File a separate ticket for each?", strings.Join(dirs, ", "))) {
+		return nil, nil
+	}
+	var keys []string
+	for _, a := range touched {
+		areaInfo := *commitInfo
+		areaInfo.Title = fmt.Sprintf("%s (%s)", commitInfo.Title, a.Dir)
+		areaInfo.Body = strings.TrimSpace(fmt.Sprintf("%s\n\nThis change spans %s and was split into a ticket per area. Files in %s:\n%s",
+			commitInfo.Body, strings.Join(dirs, ", "), a.Dir, strings.Join(a.Files, "\n")))
+		var components []string
+		if a.Component != "" {
+			components = []string{a.Component}
+		}
+		// the sprint board belongs to the main project, so only its tickets go in the sprint
+		issue, err := createIssue(ctx, jiraClient, &areaInfo, a.Project, components, (*addToCurrentSprintFlag || *addToNextSprintFlag) && a.Project == jiraProjectName)
+		if err != nil {
+			if len(keys) > 0 {
+				return nil, fmt.Errorf("created %s but failed to create the ticket for %s: %w", strings.Join(keys, ", "), a.Dir, err)
+			}
+			return nil, err
+		}
+		if *dryRun {
+			// previewed, but there's nothing to link
+			keys = append(keys, "")
+			continue
+		}
+		fmt.Printf("Created %s for %s\n", issue.Key, a.Dir)
+		keys = append(keys, issue.Key)
+	}
+	if *dryRun {
+		return keys, nil
+	}
+	for _, key := range keys[1:] {
+		link := &jira.IssueLink{
+			Type:         jira.IssueLinkType{Name: "Relates"},
+			InwardIssue:  &jira.Issue{Key: keys[0]},
+			OutwardIssue: &jira.Issue{Key: key},
+		}
+		if _, err := jiraClient.Issue.AddLinkWithContext(ctx, link); err != nil {
+			warnf("failed to link %s to %s: %v", key, keys[0], err)
+		}
+	}
+	commitInfo.RelatedKeys = keys[1:]
+	return keys, nil
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/andygrunwald/go-jira"
 	"github.com/google/go-github/v37/github"
@@ -17,178 +27,2096 @@ import (
 
 var addToCurrentSprintFlag = flag.Bool("addToCurrentSprint", false, "add the ticket to the current sprint")
 
+var addToNextSprintFlag = flag.Bool("addToNextSprint", false, "add the ticket to the next future sprint instead of the current one")
+
+var sprintByDate = flag.Bool("sprintByDate", false, "with -addToCurrentSprint, pick the sprint whose dates contain today rather than the first active one")
+
+var trackerName = flag.String("tracker", "jira", "issue tracker to use: jira, none, or custom (runs TRACKER_CMD)")
+
+var skipBranches = flag.String("skipBranches", "", "comma-separated branch globs (e.g. release/*) to refuse to run on, in addition to those in .autoprignore")
+
+var rankBefore = flag.String("rankBefore", "", "rank the new ticket before this issue key in the backlog")
+var rankAfter = flag.String("rankAfter", "", "rank the new ticket after this issue key in the backlog")
+
+var titleFlag = flag.String("title", "", "use this PR/ticket title instead of the commit message (requires -body)")
+var bodyFlag = flag.String("body", "", "use this PR/ticket body instead of the commit message (requires -title)")
+
+var requestType = flag.String("requestType", "", "request type for Jira Service Management projects, e.g. \"it/get-help\"")
+
+var validateConfig = flag.String("validateConfig", "", "check this config file for unknown keys and bad values, then exit")
+
+var dryRun = flag.Bool("dryRun", false, "print the ticket and PR that would be created without creating anything or changing the repo")
+
+var explainFlag = flag.Bool("explain", false, "print the equivalent git, gh and jira CLI commands for each action")
+
+var descriptionFromCommits = flag.Bool("descriptionFromCommits", false, "build the JIRA description from every commit on the branch, not just the last one")
+
+var fetchBase = flag.Bool("fetchBase", false, "fetch the base branch from origin first, so the commits and diffs we compare against it are up to date (recommended)")
+
+var checkTags = flag.Bool("checkTags", false, "warn about tags on the branch that haven't been pushed")
+
+var historyFile = flag.String("historyFile", "", "append a JSON line recording each created ticket and PR to this file")
+
+var assigneeFlag = flag.String("assignee", "", "JIRA account ID to assign new tickets to instead of JIRA_ACCOUNT_ID, or \"none\" to leave them unassigned")
+
+var messageFile = flag.String("messageFile", "", "read the commit message from this file instead of HEAD and write the issue key back into it, e.g. from a prepare-commit-msg hook")
+
+var noColor = flag.Bool("noColor", false, "don't color output, even on a terminal")
+
+var locale = flag.String("locale", "", "language to request JIRA responses in, e.g. \"de-DE\"")
+
+var dedupeTickets = flag.Bool("dedupeTickets", false, "reuse an open ticket with the same summary instead of creating one, linking any other copies as duplicates of the oldest")
+
+var splitByArea = flag.Bool("splitByArea", false, "when a commit spans several areas in JIRA_AREA_MAP, offer to file one linked ticket per area")
+var assumeYes = flag.Bool("assumeYes", false, "answer yes to any prompts")
+
+var dirtyMode = flag.String("dirtyMode", "tracked", "what counts as a dirty tree: tracked (modified files), all (untracked files too), or off")
+
+var transformCmd = flag.String("transformCmd", "", "shell command that gets the commit info as JSON on stdin and prints a modified version on stdout")
+
+var requireSignature = flag.Bool("requireSignature", false, "refuse to push unless HEAD has a valid signature")
+
+var authorName = flag.String("authorName", "", "author and committer name for the amended commit (requires -authorEmail)")
+var authorEmail = flag.String("authorEmail", "", "author and committer email for the amended commit (requires -authorName)")
+
+var maxSummaryLength = flag.Int("maxSummaryLength", 255, "truncate JIRA summaries to this many characters")
+
+var verbose = flag.Bool("verbose", false, "print more about what's going on")
+
+var headCommit = flag.String("headCommit", "", "open the PR for this commit (an ancestor of HEAD) on its own branch instead of for HEAD, e.g. for stacked PRs")
+var baseFlag = flag.String("base", "", "branch to open the PR against, instead of the configured base")
+
+var checkUpdate = flag.Bool("checkUpdate", false, "check whether a newer version of autopr is available")
+
+var autoLabel = flag.String("autoLabel", "autopr", "label added to every ticket we create, so they can be found with JQL; empty to turn it off")
+
+var securityLevel = flag.String("securityLevel", "", "name of the JIRA security level to create tickets with")
+
+var pushRemotes = flag.String("pushRemotes", "", "comma-separated git remotes to try pushing to, in order; the PR head uses the first one you can push to")
+
 var noPR = flag.Bool("nopr", false, "just make a ticket, don't open a PR")
 
+var prJiraComment = flag.Bool("prJiraComment", false, "post a PR comment summarizing the linked JIRA ticket")
+
+// GitHub won't take more than 15 requested reviewers on a PR
+var maxReviewers = flag.Int("maxReviewers", 15, "maximum number of reviewers to request, including teams; explicitly configured reviewers and teams are kept over ones derived from e.g. git blame")
+
+var prTitleStyle = flag.String("prTitleStyle", "plain", "PR title prefix: plain (none), type (e.g. [Bug]), scope (the top-level directories changed, e.g. [api]) or typeScope ([Bug][api])")
+
+var bodyMode = flag.String("bodyMode", "commit", "where the PR body comes from: commit (the commit body), titleOnly (no body), template (-bodyTemplate) or file (-bodyFile)")
+var editPR = flag.Bool("editPR", false, "review and edit the PR title and body in $VISUAL or $EDITOR before submitting it, when run from a terminal")
+var emptyBodyBehavior = flag.String("emptyBodyBehavior", "blank", "what to do when the PR body would be empty: blank (leave it), copyTitle, template (-bodyTemplate) or prompt (write one in $EDITOR, on a terminal)")
+var bodyTemplate = flag.String("bodyTemplate", ".github/pull_request_template.md", "PR template used by -bodyMode template")
+var autoTemplate = flag.Bool("autoTemplate", false, "when most of the diff is one language, use <templateDir>/<extension>.md (e.g. go.md) as the template if there is one")
+var templateDir = flag.String("templateDir", ".github/PULL_REQUEST_TEMPLATE", "directory of per-language templates for -autoTemplate")
+var bodyFile = flag.String("bodyFile", "", "file used as the PR body by -bodyMode file")
+
+var sinceLastPR = flag.Bool("sinceLastPR", false, "build the PR body from the commits added since the branch's last merged PR")
+
+var embedJiraDescription = flag.Bool("embedJiraDescription", false, "quote the JIRA ticket's description in the PR body")
+
+var labelRules = flag.String("labelRules", "", "YAML file of regexes matched against the commit message and the PR labels they add")
+
+var deleteBranchHint = flag.Bool("deleteBranchHint", false, "remind reviewers to delete the branch after merging")
+var deleteBranchHintText = flag.String("deleteBranchHintText", "Please delete this branch after merging.", "comment text for -deleteBranchHint")
+var deleteBranchHintLabel = flag.String("deleteBranchHintLabel", "", "label to apply for -deleteBranchHint instead of commenting")
+
+var blameReviewers = flag.Bool("blameReviewers", false, "request a review from whoever wrote most of the code being changed, per git blame (slow on big diffs)")
+
+var recordDeployment = flag.String("recordDeployment", "", "record the PR and ticket as a GitHub deployment to this environment, e.g. \"autopr\"")
+
+var ticketSummaryComment = flag.Bool("ticketSummaryComment", false, "post a PR comment with a table of the ticket's planning fields")
+
+var detailInComment = flag.Bool("detailInComment", false, "keep the PR body short and post the full description as the first PR comment")
+
 // secrets!
-var githubToken = os.Getenv("GITHUB_TOKEN")
-var jiraToken = os.Getenv("JIRA_TOKEN")
+var githubToken = getsecret("GITHUB_TOKEN")
+var jiraToken = getsecret("JIRA_TOKEN")
 
 // not really secrets but stuff where you're likely to differ from me!
-var targetGithubOrg = os.Getenv("TARGET_GITHUB_ORG")
-var sourceGithubOrg = os.Getenv("SOURCE_GITHUB_ORG")
-var targetGithubRepo = os.Getenv("TARGET_GITHUB_REPO")
-var jiraAccountId = os.Getenv("JIRA_ACCOUNT_ID")
-var jiraUsername = os.Getenv("JIRA_USER_NAME")
-var jiraUrl = os.Getenv("JIRA_URL")
-var jiraProjectName = os.Getenv("JIRA_PROJECT_NAME")
-var jiraBoardID = os.Getenv("JIRA_BOARD_ID")
-var jiraSprintFieldName = os.Getenv("JIRA_SPRINT_FIELD_NAME")
-var jiraParentId = os.Getenv("JIRA_PARENT_ID")
-
-const jiraIssueType = "Technical Task"
-const targetGithubBranch = "main"
+var githubBaseURL = getenv("GITHUB_BASE_URL")     // for GitHub Enterprise, e.g. https://github.example.com/
+var githubUploadURL = getenv("GITHUB_UPLOAD_URL") // derived from GITHUB_BASE_URL if unset
+var targetGithubOrg = getenv("TARGET_GITHUB_ORG")
+var sourceGithubOrg = getenv("SOURCE_GITHUB_ORG")
+var targetGithubRepo = getenv("TARGET_GITHUB_REPO")
+var jiraAccountId = getenv("JIRA_ACCOUNT_ID")
+var jiraUsername = getenv("JIRA_USER_NAME")
+var jiraUrl = getenv("JIRA_URL")
+var jiraProjectName = getenv("JIRA_PROJECT_NAME")
+var jiraBoardID = getenv("JIRA_BOARD_ID")
+var jiraSprintFieldName = getenv("JIRA_SPRINT_FIELD_NAME")
+var jiraLabels = getenv("JIRA_LABELS")          // comma-separated labels for new tickets, on top of -autoLabel
+var jiraSprintName = getenv("JIRA_SPRINT_NAME") // only use sprints with this in their name, for boards shared by several teams
+var jiraParentId = getenv("JIRA_PARENT_ID")
+var jiraRequestTypeFieldName = getenv("JIRA_REQUEST_TYPE_FIELD_NAME")
+
+// the status new tickets are moved to, since we're already opening a PR for them
+var jiraStartStatus = getenvDefault("JIRA_START_STATUS", "In Progress")
+
+// comma-separated GitHub users, teams (slugs) and labels to add to every PR
+var prReviewers = getenv("PR_REVIEWERS")
+var prTeamReviewers = getenv("PR_TEAM_REVIEWERS")
+var prLabels = getenv("PR_LABELS")
+
+// comma-separated directory to project (and optionally component) pairs, e.g. "api=API,web=FE/Frontend"
+var jiraAreaMap = getenv("JIRA_AREA_MAP")
+
+// comma-separated branch prefix to issue type pairs, e.g. "bug=Bug,feat=Story"
+var jiraBranchIssueTypes = getenv("JIRA_BRANCH_ISSUE_TYPES")
+
+var jiraIssueType = getenvDefault("JIRA_ISSUE_TYPE", "Technical Task")
+
+// if this is empty we use the target repo's default branch
+var targetGithubBranch = getenv("TARGET_GITHUB_BRANCH")
 
 func main() {
+	flag.Parse()
+	if *validateConfig != "" {
+		// before loading settings, since a broken file is what we're here to find
+		problems := validateConfigFile(*validateConfig)
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", *validateConfig, p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("%s: ok\n", *validateConfig)
+		return
+	}
+	if settingsErr != nil {
+		fatal(settingsErr)
+	}
+	if err := applyConfigToFlags(flag.CommandLine); err != nil {
+		fatal(err)
+	}
+	if err := applyBranchConfig(); err != nil {
+		fatal(gitError(err))
+	}
+	if err := validateBodyMode(); err != nil {
+		fatal(err)
+	}
+	if (*authorName == "") != (*authorEmail == "") {
+		fatal(errors.New("-authorName and -authorEmail must be used together"))
+	}
+	if *addToCurrentSprintFlag && *addToNextSprintFlag {
+		fatal(errors.New("only one of -addToCurrentSprint and -addToNextSprint can be set"))
+	}
+	switch *prTitleStyle {
+	case "plain", "type", "scope", "typeScope":
+	default:
+		fatal(fmt.Errorf("unknown -prTitleStyle %q, must be plain, type, scope or typeScope", *prTitleStyle))
+	}
 	if githubToken == "" {
-		fmt.Println("GITHUB_TOKEN env var must be set")
-		os.Exit(1)
+		fatal(errors.New("GITHUB_TOKEN env var must be set"))
+	}
+	if err := validateGithubBaseURL(); err != nil {
+		fatal(err)
+	}
+	if strings.TrimSpace(jiraIssueType) == "" {
+		fatal(errors.New("JIRA_ISSUE_TYPE must not be empty"))
+	}
+	if err := run(context.Background()); err != nil {
+		fatal(err)
+	}
+}
+
+// outcome is a one-line record of what the run did, printed on stderr at the end so it ends up in logs
+// whatever happens to stdout, and even if the run fails partway. The subcommands add to it too.
+var outcome []string
+
+func run(ctx context.Context) (err error) {
+	if *checkUpdate {
+		defer startUpdateCheck()()
+	}
+	// release branches are often ignored for regular runs, so don't check them for releases
+	if flag.Arg(0) != "release" {
+		if branch, pattern, err := ignoredBranch(); err != nil {
+			return gitError(err)
+		} else if pattern != "" {
+			fmt.Printf("Branch %s matches ignore pattern %q, nothing to do\n", branch, pattern)
+			return nil
+		}
 	}
-	if jiraToken == "" {
-		fmt.Println("JIRA_TOKEN env var must be set")
-		os.Exit(1)
+	rules, err := loadLabelRules(*labelRules)
+	if err != nil {
+		return err
 	}
-	ctx := context.Background()
+	ctx, runSpan := startSpan(ctx, "autopr", "repo", targetGithubOrg+"/"+targetGithubRepo)
+	defer func() {
+		runSpan.End(err)
+		flushSpans(context.Background())
+	}()
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: githubToken},
 	)
 	tc := oauth2.NewClient(ctx, ts)
 
-	githubClient := github.NewClient(tc)
-	tp := jira.BasicAuthTransport{
-		Username: jiraUsername,
-		Password: jiraToken,
+	githubClient, err := newGithubClient(tc)
+	if err != nil {
+		return err
 	}
-
-	jiraClient, err := jira.NewClient(tp.Client(), jiraUrl)
+	if targetGithubBranch == "" {
+		if targetGithubBranch, err = defaultBranch(ctx, githubClient); err != nil {
+			return githubError(err)
+		}
+	}
+	tracker, err := newTracker(ctx, *trackerName)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if *dryRun && flag.Arg(0) != "" {
+		return fmt.Errorf("-dryRun isn't supported for %s", flag.Arg(0))
+	}
+	defer func() {
+		if len(outcome) == 0 {
+			return
+		}
+		summary := "autopr: " + strings.Join(outcome, ", ")
+		if err != nil {
+			// the error itself is printed after this, but say how far we got so nothing gets orphaned
+			summary += ", then failed"
+		}
+		fmt.Fprintln(os.Stderr, summary)
+	}()
+	// the subcommands compare against origin/<base> as well, so this comes before them
+	if *fetchBase {
+		if err := fetchBaseBranch(); err != nil {
+			return gitError(err)
+		}
+	}
+	switch flag.Arg(0) {
+	case "release":
+		return runRelease(ctx, githubClient, tracker, flag.Args()[1:])
+	case "sync":
+		return runSync(ctx, githubClient, tracker, flag.Args()[1:])
+	case "split":
+		return runSplit(ctx, githubClient, tracker, flag.Args()[1:])
 	}
-	commitInfo, err := getCommitInfo(ctx)
+	gitCtx, gitSpan := startSpan(ctx, "git")
+	commitInfo, err := getCommitInfo(gitCtx)
+	gitSpan.End(err)
 	if err != nil {
-		panic(err)
+		return gitError(err)
 	}
+	runSpan.SetAttribute("branch", commitInfo.Branch)
+	if *transformCmd != "" {
+		transformCommitInfo(ctx, *transformCmd, commitInfo)
+	}
+	var issueKey string
+	// whether we put the key on the commit, in which case the PR gets a link to the ticket
+	var addedKey bool
 	if match := regexp.MustCompile(`^[A-Z]+-\d+`).FindStringSubmatch(commitInfo.Title); len(match) == 0 {
 		// we don't have an issue number in the commit title, better create a JIRA ticket!
-		issue, err := createIssue(ctx, jiraClient, commitInfo, *addToCurrentSprintFlag)
+		createCtx, createSpan := startSpan(ctx, "jira-create")
+		var created bool
+		issueKey, created, err = tracker.CreateIssue(createCtx, commitInfo)
+		createSpan.SetAttribute("jira.key", issueKey)
+		createSpan.End(err)
 		if err != nil {
-			panic(err)
-		}
-		if err := transitionIssueToDeveloping(ctx, jiraClient, issue); err != nil {
-			panic(err)
+			return jiraError(err)
 		}
-		if err := addIssueKeyToCommit(ctx, commitInfo, issue.Key); err != nil {
-			panic(err)
+		if issueKey != "" && *dryRun {
+			commitInfo.Title = fmt.Sprintf("%s: %s", issueKey, commitInfo.Title)
+		} else if issueKey != "" {
+			if created {
+				// from here on, make sure a failure says which ticket was made so it doesn't get orphaned
+				defer func(issueKey string) {
+					if err != nil {
+						err = fmt.Errorf("%w (ticket %s was already created)", err, issueKey)
+					}
+				}(issueKey)
+			}
+			if err := tracker.Transition(ctx, issueKey); err != nil {
+				return jiraError(err)
+			}
+			if err := addIssueKeyToCommit(ctx, commitInfo, issueKey); err != nil {
+				return gitError(err)
+			}
+			addedKey = true
+			outcome = append(outcome, ticketOutcome(issueKey, created))
+			fmt.Println("Ticket:", highlight(issueKey))
 		}
+	} else {
+		issueKey = match[0]
+		outcome = append(outcome, "using "+issueKey)
+	}
+	runSpan.SetAttribute("jira.key", issueKey)
+	if commitInfo.MessageFile != "" {
+		// the commit isn't finished yet, so there's nothing to push
+		return nil
+	}
+
+	if *prTitleStyle != "plain" && !*noPR {
+		commitInfo.Title = styledTitle(ctx, tracker, issueKey, commitInfo)
+	}
+	if *dryRun {
+		return previewPR(ctx, githubClient, commitInfo)
 	}
 
-	if err := forcePushBranch(ctx, commitInfo.Branch); err != nil {
-		panic(err)
+	if *checkTags {
+		warnUnpushedTags(ctx, commitInfo.Rev)
+	}
+	// check right before pushing, since adding the issue key amends (and possibly re-signs) HEAD
+	if *requireSignature {
+		if err := verifySignature(commitInfo.Rev); err != nil {
+			return gitError(err)
+		}
 	}
+	if *headCommit != "" {
+		// point the PR's branch at the chosen commit
+		explain("git", "branch", "-f", commitInfo.Branch, *headCommit)
+		if out, err := exec.Command("git", "branch", "-f", commitInfo.Branch, *headCommit).CombinedOutput(); err != nil {
+			return gitError(fmt.Errorf("failed to create branch %s: %w\n%s", commitInfo.Branch, err, out))
+		}
+	}
+	if *pushRemotes != "" {
+		choosePushRemote(commitInfo.Branch, strings.Split(*pushRemotes, ","))
+	}
+	pushCtx, pushSpan := startSpan(ctx, "push")
+	err = forcePushBranch(pushCtx, commitInfo.Branch)
+	pushSpan.End(err)
+	if err != nil {
+		return gitError(fmt.Errorf("failed to push %s: %w", commitInfo.Branch, err))
+	}
+	outcome = append(outcome, "pushed "+commitInfo.Branch)
+	var prURL string
 	if !*noPR {
-		url, err := createPR(ctx, githubClient, commitInfo)
+		checkPermissions(ctx, githubClient)
+		body, err := prBody(ctx, githubClient, commitInfo)
+		if err != nil {
+			return githubError(err)
+		}
+		commitInfo.Body = body
+		if jt, ok := tracker.(*jiraTracker); ok && *embedJiraDescription && issueKey != "" {
+			desc, err := getJiraDescription(ctx, jt.client, issueKey)
+			if err != nil {
+				return jiraError(err)
+			}
+			commitInfo.Body = embedDescription(commitInfo.Body, desc)
+		}
+		if _, ok := tracker.(*jiraTracker); ok && addedKey && jiraUrl != "" {
+			// tickets that already existed were probably linked by whoever wrote the commit
+			commitInfo.TicketLink = fmt.Sprintf("JIRA: [%s](%s)", issueKey, jiraBrowseURL(issueKey))
+		}
+		if *editPR && stdinIsTerminal() {
+			if commitInfo.Title, commitInfo.Body, err = editPRText(commitInfo.Title, commitInfo.Body); err != nil {
+				return err
+			}
+		}
+		prCtx, prSpan := startSpan(ctx, "pr-create")
+		pr, newPR, err := createPR(prCtx, githubClient, commitInfo)
+		prSpan.SetAttribute("github.pr_url", pr.GetHTMLURL())
+		prSpan.End(err)
 		if err != nil {
-			panic(err)
+			return githubError(fmt.Errorf("failed to create PR: %w", err))
+		}
+		prURL = pr.GetHTMLURL()
+		fmt.Println("PR:", highlight(prURL))
+		outcome = append(outcome, prOutcome(pr, newPR))
+		// only on the first run, so re-running on an existing PR doesn't pile up comments
+		if issueKey != "" && newPR {
+			if err := tracker.AddComment(ctx, issueKey, "PR: "+prURL); err != nil {
+				warnf("failed to add the PR link to %s: %v", issueKey, err)
+			}
+			if err := tracker.Link(ctx, issueKey, prURL, fmt.Sprintf("PR #%d: %s", pr.GetNumber(), pr.GetTitle())); err != nil {
+				warnf("failed to link %s to the PR: %v", issueKey, err)
+			}
+		}
+		if jt, ok := tracker.(*jiraTracker); ok && *prJiraComment && issueKey != "" {
+			if err := postJiraSummaryComment(ctx, githubClient, jt.client, pr.GetNumber(), issueKey); err != nil {
+				warnf("failed to post JIRA summary comment: %v", err)
+			}
+		}
+		if jt, ok := tracker.(*jiraTracker); ok && *ticketSummaryComment && issueKey != "" {
+			if err := postTicketFieldsComment(ctx, githubClient, jt.client, pr.GetNumber(), issueKey); err != nil {
+				warnf("failed to post ticket summary comment: %v", err)
+			}
+		}
+		labels := splitList(prLabels)
+		if *labelRules != "" {
+			labels = append(labels, matchLabels(rules, commitInfo)...)
+		}
+		addLabels(ctx, githubClient, pr.GetNumber(), labels)
+		var derivedReviewers []string
+		if *blameReviewers {
+			if reviewer, err := blameReviewer(ctx, githubClient, commitInfo.Rev); err != nil {
+				warnf("failed to find a reviewer from git blame: %v", err)
+			} else if reviewer != "" {
+				derivedReviewers = append(derivedReviewers, reviewer)
+			}
+		}
+		requestReviewers(ctx, githubClient, pr.GetNumber(), splitList(prReviewers), derivedReviewers, splitList(prTeamReviewers))
+		if *recordDeployment != "" {
+			if err := createDeployment(ctx, githubClient, pr, issueKey, *recordDeployment); err != nil {
+				warnf("failed to record deployment: %v", err)
+			}
+		}
+		if *deleteBranchHint {
+			if err := addDeleteBranchHint(ctx, githubClient, pr.GetNumber()); err != nil {
+				warnf("failed to add delete-branch hint: %v", err)
+			}
+		}
+	}
+	if *historyFile != "" {
+		if err := appendHistory(*historyFile, commitInfo.Branch, prURL, issueKey); err != nil {
+			warnf("failed to write history: %v", err)
+		}
+	}
+	return nil
+}
+
+func ticketOutcome(issueKey string, created bool) string {
+	if created {
+		return "created " + issueKey
+	}
+	return "reused " + issueKey
+}
+
+func prOutcome(pr *github.PullRequest, created bool) string {
+	if created {
+		return fmt.Sprintf("opened PR #%d", pr.GetNumber())
+	}
+	return fmt.Sprintf("updated PR #%d", pr.GetNumber())
+}
+
+// validateGithubBaseURL checks GITHUB_BASE_URL up front, since a bad one otherwise only shows up as a 404
+// when creating the PR.
+func validateGithubBaseURL() error {
+	for name, value := range map[string]string{"GITHUB_BASE_URL": githubBaseURL, "GITHUB_UPLOAD_URL": githubUploadURL} {
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http(s) URL like https://github.example.com/, not %q", name, value)
+		}
+	}
+	if githubUploadURL != "" && githubBaseURL == "" {
+		return errors.New("GITHUB_UPLOAD_URL needs GITHUB_BASE_URL")
+	}
+	return nil
+}
+
+// newGithubClient talks to github.com, or to GitHub Enterprise if GITHUB_BASE_URL is set.
+func newGithubClient(tc *http.Client) (*github.Client, error) {
+	if githubBaseURL == "" {
+		return github.NewClient(tc), nil
+	}
+	uploadURL := githubUploadURL
+	if uploadURL == "" {
+		// Enterprise serves uploads from /api/uploads next to /api/v3; NewEnterpriseClient adds the
+		// suffixes itself when given the bare host
+		uploadURL = strings.Replace(strings.TrimSuffix(githubBaseURL, "/"), "/api/v3", "/api/uploads", 1)
+	}
+	return github.NewEnterpriseClient(githubBaseURL, uploadURL, tc)
+}
+
+// defaultBranch looks up the target repo's default branch, for when TARGET_GITHUB_BRANCH isn't set.
+func defaultBranch(ctx context.Context, githubClient *github.Client) (string, error) {
+	repo, _, err := githubClient.Repositories.Get(ctx, targetGithubOrg, targetGithubRepo)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the default branch of %s/%s (set TARGET_GITHUB_BRANCH to skip this): %w", targetGithubOrg, targetGithubRepo, err)
+	}
+	return repo.GetDefaultBranch(), nil
+}
+
+// previewPR prints the push and PR a -dryRun would have made.
+func previewPR(ctx context.Context, githubClient *github.Client, commitInfo *commitInfo) error {
+	if *pushRemotes != "" {
+		// this only does a dry-run push, so it's safe here
+		choosePushRemote(commitInfo.Branch, strings.Split(*pushRemotes, ","))
+	}
+	fmt.Printf("Would push %s to %s\n", commitInfo.Branch, pushRemote)
+	if *noPR {
+		return nil
+	}
+	body, err := prBody(ctx, githubClient, commitInfo)
+	if err != nil {
+		return githubError(err)
+	}
+	head := fmt.Sprintf("%s:%s", sourceGithubOrg, commitInfo.Branch)
+	if pr, err := existingPR(ctx, githubClient, head); err != nil {
+		return githubError(err)
+	} else if pr != nil {
+		fmt.Printf("Would update %s:\n", pr.GetHTMLURL())
+	} else {
+		fmt.Printf("Would open a PR on %s/%s:\n", targetGithubOrg, targetGithubRepo)
+	}
+	fmt.Println("  Head:", head)
+	fmt.Println("  Base:", targetGithubBranch)
+	fmt.Println("  Title:", commitInfo.Title)
+	fmt.Printf("  Body:\n%s\n", body)
+	return nil
+}
+
+// requestReviewers asks for reviews, keeping to -maxReviewers, which counts teams as well as users. Explicitly
+// configured reviewers and teams take priority over ones we worked out ourselves (e.g. from git blame).
+func requestReviewers(ctx context.Context, githubClient *github.Client, prNumber int, explicit []string, derived []string, teams []string) {
+	reviewers, teams, dropped := limitReviewers(explicit, teams, derived, *maxReviewers)
+	if len(dropped) > 0 {
+		warnf("only requesting %d reviewers, dropped %s", *maxReviewers, strings.Join(dropped, ", "))
+	}
+	if len(reviewers) == 0 && len(teams) == 0 {
+		return
+	}
+	req := github.ReviewersRequest{Reviewers: reviewers, TeamReviewers: teams}
+	if _, _, err := githubClient.PullRequests.RequestReviewers(ctx, targetGithubOrg, targetGithubRepo, prNumber, req); err == nil {
+		fmt.Println("Requested review from", strings.Join(append(append([]string{}, reviewers...), teams...), ", "))
+		return
+	}
+	// GitHub rejects the whole request if any one name is wrong, so go one by one to find it
+	for _, r := range reviewers {
+		requestReview(ctx, githubClient, prNumber, r, github.ReviewersRequest{Reviewers: []string{r}})
+	}
+	for _, t := range teams {
+		requestReview(ctx, githubClient, prNumber, t, github.ReviewersRequest{TeamReviewers: []string{t}})
+	}
+}
+
+func requestReview(ctx context.Context, githubClient *github.Client, prNumber int, name string, req github.ReviewersRequest) {
+	if _, _, err := githubClient.PullRequests.RequestReviewers(ctx, targetGithubOrg, targetGithubRepo, prNumber, req); err != nil {
+		warnf("failed to request a review from %s: %v", name, err)
+	} else {
+		fmt.Println("Requested review from", name)
+	}
+}
+
+// limitReviewers dedupes the reviewers and cuts them off at max, counting teams too. Explicit users
+// come first, then teams, then derived users, so the ones nobody asked for by name are dropped first.
+func limitReviewers(explicit []string, teams []string, derived []string, max int) (users []string, keptTeams []string, dropped []string) {
+	seenUsers, seenTeams := map[string]bool{}, map[string]bool{}
+	add := func(names []string, seen map[string]bool, kept *[]string) {
+		for _, r := range names {
+			if r == "" || seen[strings.ToLower(r)] {
+				continue
+			}
+			seen[strings.ToLower(r)] = true
+			if max > 0 && len(users)+len(keptTeams) >= max {
+				dropped = append(dropped, r)
+			} else {
+				*kept = append(*kept, r)
+			}
 		}
-		fmt.Println("PR:", url)
 	}
+	add(explicit, seenUsers, &users)
+	add(teams, seenTeams, &keptTeams)
+	add(derived, seenUsers, &users)
+	return users, keptTeams, dropped
+}
+
+type historyRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Repo      string    `json:"repo"`
+	Branch    string    `json:"branch"`
+	PRURL     string    `json:"pr_url,omitempty"`
+	JiraKey   string    `json:"jira_key,omitempty"`
 }
 
-func createPR(ctx context.Context, githubClient *github.Client, commitInfo *commitInfo) (string, error) {
-	pr, _, err := githubClient.PullRequests.Create(ctx, targetGithubOrg, targetGithubRepo, &github.NewPullRequest{
-		Title: &commitInfo.Title,
-		Head:  stringPtr(fmt.Sprintf("%s:%s", sourceGithubOrg, commitInfo.Branch)),
-		Base:  stringPtr(targetGithubBranch),
-		Body:  &commitInfo.Body,
+// appendHistory records a run as one JSON line. The line goes out in a single O_APPEND write
+// so concurrent runs can't interleave.
+func appendHistory(path string, branch string, prURL string, issueKey string) error {
+	line, err := json.Marshal(historyRecord{
+		Timestamp: time.Now().UTC(),
+		Repo:      targetGithubOrg + "/" + targetGithubRepo,
+		Branch:    branch,
+		PRURL:     prURL,
+		JiraKey:   issueKey,
 	})
 	if err != nil {
-		return "", err
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
 	}
-	return *pr.HTMLURL, err
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-type commitInfo struct {
-	Branch string
-	Title  string
-	Body   string
+const descriptionMarker = "<!-- autopr:description -->"
+
+// createPR opens the PR, or if the branch already has an open one (e.g. from an earlier run before an
+// amend), updates its title and body instead. created says which happened.
+func createPR(ctx context.Context, githubClient *github.Client, commitInfo *commitInfo) (pr *github.PullRequest, created bool, err error) {
+	body := commitInfo.Body
+	if *detailInComment {
+		body = summarizeBody(body)
+	}
+	summarized := body != commitInfo.Body
+	if len(commitInfo.RelatedKeys) > 0 {
+		body = strings.TrimSpace(body + "\n\nAlso: " + strings.Join(commitInfo.RelatedKeys, ", "))
+	}
+	if commitInfo.TicketLink != "" {
+		body = strings.TrimSpace(body + "\n\n" + commitInfo.TicketLink)
+	}
+	head := fmt.Sprintf("%s:%s", sourceGithubOrg, commitInfo.Branch)
+	pr, err = existingPR(ctx, githubClient, head)
+	if err != nil {
+		return nil, false, err
+	}
+	if pr != nil {
+		explain("gh", "pr", "edit", strconv.Itoa(pr.GetNumber()), "--repo", targetGithubOrg+"/"+targetGithubRepo, "--title", commitInfo.Title, "--body", body)
+		pr, _, err = githubClient.PullRequests.Edit(ctx, targetGithubOrg, targetGithubRepo, pr.GetNumber(), &github.PullRequest{
+			Title: &commitInfo.Title,
+			Body:  &body,
+		})
+	} else {
+		created = true
+		explain("gh", "pr", "create", "--repo", targetGithubOrg+"/"+targetGithubRepo, "--head", head, "--base", targetGithubBranch, "--title", commitInfo.Title, "--body", body)
+		pr, _, err = githubClient.PullRequests.Create(ctx, targetGithubOrg, targetGithubRepo, &github.NewPullRequest{
+			Title: &commitInfo.Title,
+			Head:  &head,
+			Base:  stringPtr(targetGithubBranch),
+			Body:  &body,
+		})
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if summarized {
+		if err := upsertPRComment(ctx, githubClient, pr.GetNumber(), descriptionMarker, commitInfo.Body); err != nil {
+			return pr, created, fmt.Errorf("failed to post PR description comment: %w", err)
+		}
+	}
+	return pr, created, nil
 }
 
-func getCommitInfo(ctx context.Context) (*commitInfo, error) {
-	out, err := exec.Command("git", "diff", "--stat").CombinedOutput()
+// existingPR returns the open PR for head ("owner:branch"), or nil if there isn't one. Including the owner
+// means a same-named branch on another fork doesn't count.
+func existingPR(ctx context.Context, githubClient *github.Client, head string) (*github.PullRequest, error) {
+	prs, _, err := githubClient.PullRequests.List(ctx, targetGithubOrg, targetGithubRepo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  head,
+	})
 	if err != nil {
 		return nil, err
 	}
-	if strings.TrimSpace(string(out)) != "" {
-		return nil, fmt.Errorf("Git tree dirty! Changes: \n\n%s", string(out))
+	for _, pr := range prs {
+		// double-check, since GitHub ignores a head filter it can't parse instead of failing
+		if strings.EqualFold(pr.GetHead().GetLabel(), head) {
+			return pr, nil
+		}
 	}
-	out, err = exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").CombinedOutput()
+	return nil, nil
+}
+
+const jiraSummaryMarker = "<!-- autopr:jira-summary -->"
+
+func postJiraSummaryComment(ctx context.Context, githubClient *github.Client, jiraClient *jira.Client, prNumber int, issueKey string) error {
+	issue, _, err := jiraClient.Issue.GetWithContext(ctx, issueKey, nil)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to fetch %s: %w", issueKey, err)
+	}
+	status, assignee := "Unknown", "Unassigned"
+	if issue.Fields.Status != nil {
+		status = issue.Fields.Status.Name
 	}
-	branchName := strings.TrimSpace(string(out))
-	out, err = exec.Command("git", "log", "-1", "--pretty=%B").CombinedOutput()
+	if issue.Fields.Assignee != nil {
+		assignee = issue.Fields.Assignee.DisplayName
+	}
+	body := fmt.Sprintf("**[%s](%s)**: %s\n\nStatus: %s\nAssignee: %s",
+		issue.Key, jiraBrowseURL(issue.Key), issue.Fields.Summary, status, assignee)
+	return upsertPRComment(ctx, githubClient, prNumber, jiraSummaryMarker, body)
+}
+
+const ticketFieldsMarker = "<!-- autopr:ticket-fields -->"
+
+// postTicketFieldsComment posts (or updates) a table of the ticket's planning metadata for reviewers.
+func postTicketFieldsComment(ctx context.Context, githubClient *github.Client, jiraClient *jira.Client, prNumber int, issueKey string) error {
+	issue, _, err := jiraClient.Issue.GetWithContext(ctx, issueKey, nil)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to fetch %s: %w", issueKey, err)
 	}
-	commitMsgLines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	title := commitMsgLines[0]
-	var body string
-	if len(commitMsgLines) > 2 {
-		body = strings.Join(commitMsgLines[2:], "\n")
+	assignee := "Unassigned"
+	if issue.Fields.Assignee != nil {
+		assignee = issue.Fields.Assignee.DisplayName
+	}
+	var components []string
+	for _, c := range issue.Fields.Components {
+		components = append(components, c.Name)
 	}
-	return &commitInfo{Branch: branchName, Title: title, Body: body}, nil
+	rows := [][2]string{
+		{"Type", issue.Fields.Type.Name},
+		{"Sprint", sprintNames(issue.Fields.Unknowns[jiraSprintFieldName])},
+		{"Components", strings.Join(components, ", ")},
+		{"Labels", strings.Join(issue.Fields.Labels, ", ")},
+		{"Assignee", assignee},
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**[%s](%s)**\n\n| Field | Value |\n| --- | --- |\n", issue.Key, jiraBrowseURL(issue.Key))
+	for _, row := range rows {
+		value := row[1]
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(&sb, "| %s | %s |\n", row[0], strings.ReplaceAll(value, "|", "\\|"))
+	}
+	return upsertPRComment(ctx, githubClient, prNumber, ticketFieldsMarker, sb.String())
 }
 
-func forcePushBranch(ctx context.Context, branchName string) error {
-	return exec.Command("git", "push", "origin", branchName, "-f").Run()
+var serverSprintName = regexp.MustCompile(`name=([^,\]]*)`)
+
+// sprintNames formats the sprint field, which is a list of sprint objects on Cloud and a list of
+// "com.atlassian.greenhopper.service.sprint.Sprint@...[name=...,...]" strings on Server.
+func sprintNames(field interface{}) string {
+	sprints, _ := field.([]interface{})
+	var names []string
+	for _, sprint := range sprints {
+		switch s := sprint.(type) {
+		case map[string]interface{}:
+			if name, ok := s["name"].(string); ok {
+				names = append(names, name)
+			}
+		case string:
+			if m := serverSprintName.FindStringSubmatch(s); m != nil {
+				names = append(names, m[1])
+			}
+		}
+	}
+	return strings.Join(names, ", ")
 }
 
-func createIssue(ctx context.Context, jiraClient *jira.Client, commitInfo *commitInfo, addToCurrentSprint bool) (*jira.Issue, error) {
-	extraFields := map[string]interface{}{}
-	if addToCurrentSprint {
-		boardId, _ := strconv.Atoi(jiraBoardID)
-		sprints, _, err := jiraClient.Board.GetAllSprintsWithOptionsWithContext(ctx, boardId, &jira.GetAllSprintsOptions{State: "active"})
+const jiraDescriptionStart = "<!-- autopr:jira-description -->"
+const jiraDescriptionEnd = "<!-- /autopr:jira-description -->"
+
+// getJiraDescription returns the ticket's description as plain text. It asks the v3 API, which returns
+// Atlassian Document Format that we can flatten, rather than v2's wiki markup that would show up as-is in
+// the PR. JIRA Server doesn't have v3, so there we fall back to v2's string.
+func getJiraDescription(ctx context.Context, jiraClient *jira.Client, issueKey string) (string, error) {
+	var issue struct {
+		Fields struct {
+			Description json.RawMessage `json:"description"`
+		} `json:"fields"`
+	}
+	for _, api := range []string{"3", "2"} {
+		req, err := jiraClient.NewRequestWithContext(ctx, "GET", "rest/api/"+api+"/issue/"+issueKey+"?fields=description", nil)
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		resp, err := jiraClient.Do(req, &issue)
+		if err == nil {
+			break
 		}
-		if len(sprints.Values) > 0 {
-			extraFields[jiraSprintFieldName] = sprints.Values[0].ID
+		if api == "2" || resp == nil || resp.StatusCode != http.StatusNotFound {
+			return "", fmt.Errorf("failed to fetch %s: %w", issueKey, err)
 		}
 	}
+	if len(issue.Fields.Description) == 0 || string(issue.Fields.Description) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(issue.Fields.Description, &text); err == nil {
+		return text, nil
+	}
+	var doc adfNode
+	if err := json.Unmarshal(issue.Fields.Description, &doc); err != nil {
+		return "", fmt.Errorf("unrecognized description format on %s: %w", issueKey, err)
+	}
+	return strings.TrimSpace(doc.plainText()), nil
+}
 
-	i := jira.Issue{
-		Fields: &jira.IssueFields{
-			Assignee: &jira.User{
-				AccountID: jiraAccountId,
-			},
-			Description: commitInfo.Body,
-			Type: jira.IssueType{
-				Name: jiraIssueType,
-			},
-			Project: jira.Project{
-				Key: jiraProjectName,
-			},
-			Summary:  commitInfo.Title,
-			Unknowns: extraFields,
-		},
+type adfNode struct {
+	Type    string    `json:"type"`
+	Text    string    `json:"text"`
+	Content []adfNode `json:"content"`
+}
+
+func (n adfNode) plainText() string {
+	switch n.Type {
+	case "text":
+		return n.Text
+	case "hardBreak":
+		return "\n"
 	}
-	if jiraParentId != "" {
-		i.Fields.Parent = &jira.Parent{ID: jiraParentId}
+	var sb strings.Builder
+	for _, c := range n.Content {
+		sb.WriteString(c.plainText())
+	}
+	switch n.Type {
+	case "paragraph", "heading", "listItem", "codeBlock", "blockquote":
+		sb.WriteString("\n")
 	}
+	return sb.String()
+}
 
-	issue, _, err := jiraClient.Issue.CreateWithContext(ctx, &i)
-	return issue, err
+// embedDescription adds desc to body as a quoted block, replacing the block from a previous run if there is one.
+func embedDescription(body string, desc string) string {
+	if start := strings.Index(body, jiraDescriptionStart); start != -1 {
+		if end := strings.Index(body[start:], jiraDescriptionEnd); end != -1 {
+			body = strings.TrimSpace(body[:start] + body[start+end+len(jiraDescriptionEnd):])
+		}
+	}
+	if strings.TrimSpace(desc) == "" {
+		return body
+	}
+	lines := strings.Split(strings.TrimSpace(desc), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	block := jiraDescriptionStart + "\n" + strings.Join(lines, "\n") + "\n" + jiraDescriptionEnd
+	if body == "" {
+		return block
+	}
+	return body + "\n\n" + block
 }
 
-func transitionIssueToDeveloping(ctx context.Context, jiraClient *jira.Client, issue *jira.Issue) error {
-	if _, err := jiraClient.Issue.DoTransitionWithContext(ctx, issue.ID, "121"); err != nil {
-		return fmt.Errorf("failed to transition to developing: %w", err)
+// createDeployment records the PR as a deployment, for dashboards that watch deployments. The ref is the
+// PR's head ref in the target repo, which exists even when the branch lives in a fork.
+func createDeployment(ctx context.Context, githubClient *github.Client, pr *github.PullRequest, issueKey string, environment string) error {
+	description := fmt.Sprintf("autopr: PR #%d", pr.GetNumber())
+	if issueKey != "" {
+		description += " for " + issueKey
 	}
-	return nil
+	_, resp, err := githubClient.Repositories.CreateDeployment(ctx, targetGithubOrg, targetGithubRepo, &github.DeploymentRequest{
+		Ref:              stringPtr(fmt.Sprintf("refs/pull/%d/head", pr.GetNumber())),
+		Task:             stringPtr("autopr"),
+		AutoMerge:        github.Bool(false),
+		RequiredContexts: &[]string{},
+		Environment:      &environment,
+		Description:      &description,
+		Payload:          map[string]string{"jira_key": issueKey, "pr_url": pr.GetHTMLURL()},
+	})
+	if resp != nil && (resp.StatusCode == 403 || resp.StatusCode == 404) {
+		return fmt.Errorf("the token can't create deployments in %s/%s (needs repo_deployment scope): %w", targetGithubOrg, targetGithubRepo, err)
+	}
+	return err
 }
 
-func addIssueKeyToCommit(ctx context.Context, commitInfo *commitInfo, issueKey string) error {
-	commitInfo.Title = fmt.Sprintf("%s: %s", issueKey, commitInfo.Title)
-	return exec.Command("git", "commit", "--amend", "-m", fmt.Sprintf("%s\n\n%s", commitInfo.Title, commitInfo.Body)).Run()
+const deleteBranchHintMarker = "<!-- autopr:delete-branch-hint -->"
+
+// addDeleteBranchHint nudges whoever merges to clean up the branch, since we can't turn on
+// "automatically delete head branches" for a single PR.
+func addDeleteBranchHint(ctx context.Context, githubClient *github.Client, prNumber int) error {
+	if *deleteBranchHintLabel != "" {
+		_, _, err := githubClient.Issues.AddLabelsToIssue(ctx, targetGithubOrg, targetGithubRepo, prNumber, []string{*deleteBranchHintLabel})
+		return err
+	}
+	return upsertPRComment(ctx, githubClient, prNumber, deleteBranchHintMarker, *deleteBranchHintText)
+}
+
+// upsertPRComment posts a comment tagged with marker, or edits the existing one if a previous run already posted it.
+func upsertPRComment(ctx context.Context, githubClient *github.Client, prNumber int, marker string, body string) error {
+	body = marker + "\n" + body
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := githubClient.Issues.ListComments(ctx, targetGithubOrg, targetGithubRepo, prNumber, opts)
+		if err != nil {
+			return err
+		}
+		for _, c := range comments {
+			if strings.Contains(c.GetBody(), marker) {
+				_, _, err := githubClient.Issues.EditComment(ctx, targetGithubOrg, targetGithubRepo, c.GetID(), &github.IssueComment{Body: &body})
+				return err
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	_, _, err := githubClient.Issues.CreateComment(ctx, targetGithubOrg, targetGithubRepo, prNumber, &github.IssueComment{Body: &body})
+	return err
+}
+
+// summarizeBody returns the first paragraph of a commit body.
+func summarizeBody(body string) string {
+	return strings.TrimSpace(strings.SplitN(body, "\n\n", 2)[0])
+}
+
+type commitInfo struct {
+	Branch string `json:"branch"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	// KeepCommit is set when the commit itself shouldn't be amended, e.g. because the title came from
+	// -title or the commit isn't HEAD.
+	KeepCommit bool `json:"keepCommit,omitempty"`
+	// MessageFile is set when the message came from -messageFile, for a commit that hasn't been made yet.
+	MessageFile string `json:"messageFile,omitempty"`
+	// HeadMessage is set when the title and body summarize several commits. It's HEAD's own message,
+	// which is what gets the issue key when amending.
+	HeadMessage string `json:"-"`
+	// Rev is the commit the PR is for: HEAD, or the SHA of -headCommit.
+	Rev string `json:"-"`
+	// TicketLink goes at the end of the PR body. It's kept separate so -detailInComment can't summarize it away.
+	TicketLink string `json:"-"`
+	// RelatedKeys are the other tickets from -splitByArea. Like TicketLink they only go in the PR body,
+	// so they don't end up in the amended commit or get dropped by other -bodyMode settings.
+	RelatedKeys []string `json:"-"`
+}
+
+func getCommitInfo(ctx context.Context) (*commitInfo, error) {
+	// with -messageFile we're mid-commit, so of course the tree is dirty
+	if *messageFile == "" {
+		if err := checkDirty(*dirtyMode); err != nil {
+			return nil, err
+		}
+	}
+	branchName, err := currentBranch()
+	if err != nil {
+		return nil, err
+	}
+	rev := "HEAD"
+	if *headCommit != "" {
+		if rev, err = resolveHeadCommit(*headCommit); err != nil {
+			return nil, err
+		}
+		branchName = fmt.Sprintf("%s-%s", branchName, rev[:8])
+	}
+	if (*titleFlag == "") != (*bodyFlag == "") {
+		return nil, fmt.Errorf("-title and -body must be used together")
+	}
+	if *titleFlag != "" {
+		return &commitInfo{Branch: branchName, Rev: rev, Title: *titleFlag, Body: *bodyFlag, KeepCommit: true}, nil
+	}
+	if *messageFile != "" {
+		msg, err := readMessageFile(*messageFile)
+		if err != nil {
+			return nil, err
+		}
+		title, body := splitCommitMessage(msg)
+		return &commitInfo{Branch: branchName, Rev: rev, Title: title, Body: body, MessageFile: *messageFile}, nil
+	}
+	out, err := exec.Command("git", "log", "-1", "--pretty=%B", rev).CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+	title, body := splitCommitMessage(string(out))
+	info := &commitInfo{Branch: branchName, Rev: rev, Title: title, Body: body, KeepCommit: rev != "HEAD"}
+	// if we can't tell what's on the branch (e.g. the base hasn't been fetched), just use the one commit
+	if commits, err := getBranchCommits(ctx, fmt.Sprintf("origin/%s..%s", targetGithubBranch, rev)); err == nil && len(commits) > 1 {
+		summarizeBranchCommits(info, commits, string(out))
+	}
+	return info, nil
+}
+
+// summarizeBranchCommits titles a multi-commit branch after its oldest commit and lists every commit in
+// the body. If any commit already names a ticket, the title gets that key so we don't create another.
+func summarizeBranchCommits(info *commitInfo, commits []branchCommit, headMessage string) {
+	info.Title = commits[0].Subject
+	var lines []string
+	var key string
+	for _, c := range commits {
+		lines = append(lines, "- "+c.Subject)
+		if key == "" {
+			key = issueKeyPattern.FindString(c.Subject)
+		}
+	}
+	info.Body = strings.Join(lines, "\n")
+	if key != "" && !strings.HasPrefix(info.Title, key) {
+		info.Title = key + ": " + info.Title
+	}
+	info.HeadMessage = strings.TrimSpace(headMessage)
+}
+
+// resolveHeadCommit resolves -headCommit to a full SHA, making sure it's on the current branch.
+func resolveHeadCommit(rev string) (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--verify", rev+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("-headCommit %s isn't a commit", rev)
+	}
+	sha := strings.TrimSpace(string(out))
+	if err := exec.Command("git", "merge-base", "--is-ancestor", sha, "HEAD").Run(); err != nil {
+		return "", fmt.Errorf("-headCommit %s isn't an ancestor of HEAD", rev)
+	}
+	return sha, nil
+}
+
+func checkDirty(mode string) error {
+	var args []string
+	switch mode {
+	case "tracked":
+		args = []string{"diff", "--stat"}
+	case "all":
+		args = []string{"status", "--porcelain"}
+	case "off":
+		return nil
+	default:
+		return fmt.Errorf("unknown -dirtyMode %q, must be tracked, all or off", mode)
+	}
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(out)) != "" {
+		return withExitCode(exitDirty, errors.New("git tree is dirty; commit or stash your changes first (see git status)"))
+	}
+	return nil
+}
+
+// transformCommitInfo lets a user command rewrite the title and body. If the command fails or prints
+// something we can't use, we warn and carry on with the original.
+func transformCommitInfo(ctx context.Context, command string, commitInfo *commitInfo) {
+	in, err := json.Marshal(commitInfo)
+	if err != nil {
+		warnf("-transformCmd: %v", err)
+		return
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		warnf("-transformCmd failed, using the original commit info: %v", err)
+		return
+	}
+	var transformed struct {
+		Title *string `json:"title"`
+		Body  *string `json:"body"`
+	}
+	if err := json.Unmarshal(out, &transformed); err != nil {
+		warnf("-transformCmd printed invalid JSON, using the original commit info: %v", err)
+		return
+	}
+	if transformed.Title == nil || strings.TrimSpace(*transformed.Title) == "" {
+		warnf("-transformCmd returned no title, using the original commit info")
+		return
+	}
+	// the branch has to stay put since we push it
+	commitInfo.Title = *transformed.Title
+	if transformed.Body != nil {
+		commitInfo.Body = *transformed.Body
+	}
+}
+
+func splitCommitMessage(msg string) (string, string) {
+	commitMsgLines := strings.Split(strings.TrimSpace(msg), "\n")
+	title := commitMsgLines[0]
+	var body string
+	if len(commitMsgLines) > 2 {
+		body = strings.Join(commitMsgLines[2:], "\n")
+	}
+	return title, body
+}
+
+const scissorsLine = "# ------------------------ >8 ------------------------"
+
+// readMessageFile reads a commit message file the way git will, dropping comment lines
+// and anything below the scissors line that commit -v adds.
+func readMessageFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line == scissorsLine {
+			break
+		}
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	msg := strings.TrimSpace(strings.Join(lines, "\n"))
+	if msg == "" {
+		return "", fmt.Errorf("%s has no commit message in it", path)
+	}
+	return msg, nil
+}
+
+// prefixMessageFile replaces the title line in a commit message file, leaving git's comment lines in place.
+func prefixMessageFile(path string, title string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+			lines[i] = title
+			break
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644)
+}
+
+type branchCommit struct {
+	SHA     string
+	Subject string
+	Body    string
+}
+
+// getBranchCommits returns the commits in revRange (e.g. "main..HEAD"), oldest first.
+func getBranchCommits(ctx context.Context, revRange string) ([]branchCommit, error) {
+	out, err := exec.Command("git", "log", "--reverse", "--format=%H%x00%s%x00%b%x1e", revRange).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log %s: %w", revRange, err)
+	}
+	var commits []branchCommit
+	for _, record := range strings.Split(string(out), "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x00", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		commits = append(commits, branchCommit{SHA: fields[0], Subject: fields[1], Body: strings.TrimSpace(fields[2])})
+	}
+	return commits, nil
+}
+
+// styledTitle prefixes the PR title according to -prTitleStyle. Parts we can't work out (e.g. the type of a
+// ticket that hasn't been created because of -dryRun) are left out.
+func styledTitle(ctx context.Context, tracker IssueTracker, issueKey string, commitInfo *commitInfo) string {
+	title := commitInfo.Title
+	var prefix string
+	if *prTitleStyle == "type" || *prTitleStyle == "typeScope" {
+		if jt, ok := tracker.(*jiraTracker); ok && issueKey != "" {
+			if issue, _, err := jt.client.Issue.GetWithContext(ctx, issueKey, nil); err != nil {
+				warnf("failed to get the type of %s for the PR title: %v", issueKey, err)
+			} else {
+				prefix += "[" + issue.Fields.Type.Name + "]"
+			}
+		}
+	}
+	if *prTitleStyle == "scope" || *prTitleStyle == "typeScope" {
+		if scope, err := changedScope(commitInfo.Rev); err != nil {
+			warnf("failed to work out the PR scope: %v", err)
+		} else if scope != "" {
+			prefix += "[" + scope + "]"
+		}
+	}
+	if prefix == "" {
+		return title
+	}
+	return prefix + " " + title
+}
+
+// changedScope lists the top-level directories the PR changes, e.g. "api" or "api,web".
+// Files in the repo root don't count towards it.
+func changedScope(rev string) (string, error) {
+	out, err := exec.Command("git", "diff", "--name-only", fmt.Sprintf("origin/%s...%s", targetGithubBranch, rev)).Output()
+	if err != nil {
+		return "", err
+	}
+	var dirs []string
+	seen := map[string]bool{}
+	for _, file := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		dir, _, ok := strings.Cut(file, "/")
+		if ok && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return strings.Join(dirs, ","), nil
+}
+
+// validateBodyMode checks up front that -bodyMode's inputs exist, so we don't find out after creating a ticket.
+func validateBodyMode() error {
+	var path string
+	switch *bodyMode {
+	case "titleOnly":
+		return nil
+	case "commit":
+		return validateEmptyBodyBehavior()
+	case "template":
+		path = *bodyTemplate
+	case "file":
+		if *bodyFile == "" {
+			return fmt.Errorf("-bodyMode file needs -bodyFile")
+		}
+		path = *bodyFile
+	default:
+		return fmt.Errorf("unknown -bodyMode %q, must be commit, titleOnly, template or file", *bodyMode)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("-bodyMode %s: %w", *bodyMode, err)
+	}
+	return validateEmptyBodyBehavior()
+}
+
+func validateEmptyBodyBehavior() error {
+	switch *emptyBodyBehavior {
+	case "blank", "copyTitle", "prompt":
+		return nil
+	case "template":
+		if _, err := os.Stat(*bodyTemplate); err != nil {
+			return fmt.Errorf("-emptyBodyBehavior template: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown -emptyBodyBehavior %q, must be blank, copyTitle, template or prompt", *emptyBodyBehavior)
+}
+
+// prBody works out the PR body from -bodyMode. In commit mode the body is the commit body (which -body,
+// -messageFile and -transformCmd feed into), or the commit list with -sinceLastPR. -embedJiraDescription
+// and -detailInComment then apply on top of whichever source was picked.
+func prBody(ctx context.Context, githubClient *github.Client, commitInfo *commitInfo) (string, error) {
+	switch *bodyMode {
+	case "titleOnly":
+		return "", nil
+	case "template":
+		b, err := os.ReadFile(bodyTemplatePath(commitInfo.Rev))
+		return string(b), err
+	case "file":
+		b, err := os.ReadFile(*bodyFile)
+		return string(b), err
+	}
+	body := commitInfo.Body
+	if *sinceLastPR {
+		var err error
+		if body, err = sinceLastPRBody(ctx, githubClient, commitInfo.Branch, commitInfo.Rev); err != nil {
+			return "", err
+		}
+	}
+	if strings.TrimSpace(body) == "" {
+		return emptyBody(commitInfo)
+	}
+	return body, nil
+}
+
+// emptyBody is the PR body to use when there isn't one, per -emptyBodyBehavior.
+func emptyBody(commitInfo *commitInfo) (string, error) {
+	switch *emptyBodyBehavior {
+	case "copyTitle":
+		return commitInfo.Title, nil
+	case "template":
+		b, err := os.ReadFile(bodyTemplatePath(commitInfo.Rev))
+		return string(b), err
+	case "prompt":
+		if !stdinIsTerminal() {
+			// nobody to ask
+			return "", nil
+		}
+		return editBody(commitInfo.Title)
+	}
+	return "", nil
+}
+
+// editBody opens an editor for the user to write a PR body.
+func editBody(title string) (string, error) {
+	return editText("", fmt.Sprintf("Write a description for the PR %q above the line. An empty body is fine.", title))
+}
+
+// editPRText lets the user review the PR title and body in an editor before we submit it, like git commit.
+// The first line is the title and the rest is the body. An empty title aborts.
+func editPRText(title string, body string) (string, string, error) {
+	text, err := editText(title+"\n\n"+body, "The first line is the PR title and the rest is the body. Clear the title to abort.")
+	if err != nil {
+		return "", "", err
+	}
+	title, body, _ = strings.Cut(text, "\n")
+	if title = strings.TrimSpace(title); title == "" {
+		return "", "", errors.New("aborting, the PR title is empty")
+	}
+	return title, strings.TrimSpace(body), nil
+}
+
+// editText opens $VISUAL or $EDITOR on text, with help below a scissors line, and returns whatever's above
+// the line. Unlike a commit message, lines starting with # are kept since they're markdown headings.
+func editText(text string, help string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	f, err := os.CreateTemp("", "autopr-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, "%s\n%s\n# Do not modify or remove the line above.\n# %s\n", text, scissorsLine, help)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("sh", "-c", editor+" "+shellQuote(f.Name()))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor failed: %w", err)
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	edited, _, _ := strings.Cut(string(b), scissorsLine)
+	return strings.TrimSpace(edited), nil
+}
+
+// stdinIsTerminal says whether there's someone there to answer prompts or use an editor.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// sinceLastPRBody lists the commits up to rev added since the last merged PR from this branch,
+// or since the base branch if there isn't one.
+func sinceLastPRBody(ctx context.Context, githubClient *github.Client, branchName string, rev string) (string, error) {
+	baseRange := fmt.Sprintf("origin/%s..%s", targetGithubBranch, rev)
+	revRange := baseRange
+	prs, _, err := githubClient.PullRequests.List(ctx, targetGithubOrg, targetGithubRepo, &github.PullRequestListOptions{
+		State:     "closed",
+		Head:      fmt.Sprintf("%s:%s", sourceGithubOrg, branchName),
+		Base:      targetGithubBranch,
+		Sort:      "updated",
+		Direction: "desc",
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, pr := range prs {
+		if pr.MergedAt != nil {
+			revRange = pr.GetHead().GetSHA() + ".." + rev
+			break
+		}
+	}
+	commits, err := getBranchCommits(ctx, revRange)
+	if err != nil && revRange != baseRange {
+		// the old head is often gone locally, since we amend and force-push
+		warnf("can't list the commits since the last merged PR, listing the whole branch instead: %v", err)
+		commits, err = getBranchCommits(ctx, baseRange)
+	}
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, c := range commits {
+		lines = append(lines, "- "+c.Subject)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func currentBranch() (string, error) {
+	out, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ignoredBranch returns the current branch and the first -skipBranches or .autoprignore
+// pattern it matches, if any.
+func ignoredBranch() (string, string, error) {
+	branchName, err := currentBranch()
+	if err != nil {
+		return "", "", err
+	}
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", "", err
+	}
+	ignoreFile, err := os.ReadFile(filepath.Join(strings.TrimSpace(string(out)), ".autoprignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
+	}
+	pattern, err := matchBranchPattern(branchName, branchIgnorePatterns(*skipBranches, string(ignoreFile)))
+	return branchName, pattern, err
+}
+
+// branchIgnorePatterns combines the comma-separated -skipBranches globs with the lines of an .autoprignore
+// file, leaving out blank lines and # comments.
+func branchIgnorePatterns(skip string, ignoreFile string) []string {
+	patterns := splitList(skip)
+	for _, line := range strings.Split(ignoreFile, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+// matchBranchPattern returns the first of patterns that matches branch, or "" if none do. As in path.Match,
+// * doesn't match a /, so release/* matches release/1.2 but not release/1.2/hotfix.
+func matchBranchPattern(branch string, patterns []string) (string, error) {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, branch)
+		if err != nil {
+			return "", fmt.Errorf("bad branch pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return pattern, nil
+		}
+	}
+	return "", nil
+}
+
+// warnUnpushedTags warns about tags in base..rev that the remote doesn't have, since CI for the PR
+// may depend on them. It's advisory only, so failures to check are warnings too.
+func warnUnpushedTags(ctx context.Context, rev string) {
+	out, err := exec.Command("git", "for-each-ref", "refs/tags", "--merged", rev, "--no-merged", "origin/"+targetGithubBranch, "--format=%(refname)").Output()
+	if err != nil {
+		warnf("couldn't list tags on the branch: %v", err)
+		return
+	}
+	branchTags := map[string]bool{}
+	for _, tag := range strings.Fields(string(out)) {
+		branchTags[tag] = true
+	}
+	if len(branchTags) == 0 {
+		return
+	}
+	out, err = exec.Command("git", "push", "--dry-run", "--porcelain", "origin", "--tags").Output()
+	if err != nil {
+		warnf("couldn't check for unpushed tags: %v", err)
+		return
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		// lines look like "*\trefs/tags/v1:refs/tags/v1\t[new tag]"
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		local, _, _ := strings.Cut(fields[1], ":")
+		if branchTags[local] {
+			warnf("tag %s is on this branch but hasn't been pushed", strings.TrimPrefix(local, "refs/tags/"))
+		}
+	}
+}
+
+// verifySignature checks the commit we're about to push is signed.
+func verifySignature(rev string) error {
+	out, err := exec.Command("git", "verify-commit", rev).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s isn't validly signed, not pushing: %w\n%s", rev, err, out)
+	}
+	return nil
+}
+
+// pushRemote is the remote we push branches to; sourceGithubOrg should be its owner.
+var pushRemote = "origin"
+
+var remoteOwnerPattern = regexp.MustCompile(`github[^/:]*[/:]([^/]+)/[^/]+?(\.git)?/?$`)
+
+// choosePushRemote picks the first remote we can push the branch to and uses its owner as the PR head org,
+// for fork-of-fork setups where it's not always the same one. If none of them work we stay with origin and
+// SOURCE_GITHUB_ORG. A dry-run push is used rather than ls-remote because reading a repo doesn't mean you can push to it.
+func choosePushRemote(branchName string, remotes []string) {
+	for _, remote := range remotes {
+		remote = strings.TrimSpace(remote)
+		out, err := exec.Command("git", "remote", "get-url", remote).Output()
+		if err != nil {
+			warnf("no such remote %s", remote)
+			continue
+		}
+		m := remoteOwnerPattern.FindStringSubmatch(strings.TrimSpace(string(out)))
+		if m == nil {
+			warnf("can't tell the GitHub owner of remote %s (%s)", remote, strings.TrimSpace(string(out)))
+			continue
+		}
+		if err := exec.Command("git", "push", "--dry-run", "-f", remote, branchName).Run(); err != nil {
+			continue
+		}
+		pushRemote, sourceGithubOrg = remote, m[1]
+		fmt.Printf("Pushing to %s (%s)\n", remote, sourceGithubOrg)
+		return
+	}
+	warnf("can't push to any of %s, falling back to origin (%s)", strings.Join(remotes, ", "), sourceGithubOrg)
+}
+
+// fetchBaseBranch updates origin/<base>, which everything that looks at "the commits on this branch" compares against.
+func fetchBaseBranch() error {
+	explain("git", "fetch", "origin", targetGithubBranch)
+	if out, err := exec.Command("git", "fetch", "origin", targetGithubBranch).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to fetch %s from origin: %w\n%s", targetGithubBranch, err, out)
+	}
+	return nil
+}
+
+func forcePushBranch(ctx context.Context, branchName string) error {
+	explain("git", "push", pushRemote, branchName, "-f")
+	return exec.Command("git", "push", pushRemote, branchName, "-f").Run()
+}
+
+// boardSprints lists all of the board's sprints in the given states, e.g. "active,future".
+func boardSprints(ctx context.Context, jiraClient *jira.Client, boardId int, state string) ([]jira.Sprint, error) {
+	var sprints []jira.Sprint
+	opts := &jira.GetAllSprintsOptions{State: state}
+	for {
+		page, _, err := jiraClient.Board.GetAllSprintsWithOptionsWithContext(ctx, boardId, opts)
+		if err != nil {
+			return nil, err
+		}
+		sprints = append(sprints, page.Values...)
+		if page.IsLast || len(page.Values) == 0 {
+			return sprints, nil
+		}
+		opts.StartAt += len(page.Values)
+	}
+}
+
+// sprintNameMatches says whether JIRA_SPRINT_NAME picks out the sprint. Sprint names usually change every
+// sprint (e.g. "Payments Sprint 42"), so it's a case-insensitive substring match.
+func sprintNameMatches(sprint jira.Sprint) bool {
+	return strings.Contains(strings.ToLower(sprint.Name), strings.ToLower(jiraSprintName))
+}
+
+// sprintID returns the sprint new tickets go in, or 0 if there isn't one. With -addToNextSprint that's the
+// earliest-starting future sprint. Otherwise it's the first active sprint, or with -sprintByDate the open
+// sprint whose start/end window contains now (preferring an active one if several do). Either way only
+// sprints matching JIRA_SPRINT_NAME count, and it's an error if that's set and nothing matches.
+func sprintID(ctx context.Context, jiraClient *jira.Client, boardId int) (int, error) {
+	if *addToNextSprintFlag {
+		sprints, err := boardSprints(ctx, jiraClient, boardId, "future")
+		if err != nil {
+			return 0, err
+		}
+		var next *jira.Sprint
+		for i, sprint := range sprints {
+			if !sprintNameMatches(sprint) {
+				continue
+			}
+			// sprints without a start date go last
+			if next == nil || (sprint.StartDate != nil && (next.StartDate == nil || sprint.StartDate.Before(*next.StartDate))) {
+				next = &sprints[i]
+			}
+		}
+		if next != nil {
+			return next.ID, nil
+		}
+		if jiraSprintName != "" {
+			return 0, fmt.Errorf("no future sprint on board %d matches JIRA_SPRINT_NAME %q", boardId, jiraSprintName)
+		}
+		return 0, nil
+	}
+	if *sprintByDate {
+		sprints, err := boardSprints(ctx, jiraClient, boardId, "active,future")
+		if err != nil {
+			return 0, err
+		}
+		now := time.Now()
+		var match *jira.Sprint
+		for i, sprint := range sprints {
+			if !sprintNameMatches(sprint) || sprint.StartDate == nil || sprint.EndDate == nil || now.Before(*sprint.StartDate) || !now.Before(*sprint.EndDate) {
+				continue
+			}
+			if match == nil || (match.State != "active" && sprint.State == "active") {
+				match = &sprints[i]
+			}
+		}
+		if match != nil {
+			return match.ID, nil
+		}
+	}
+	sprints, err := boardSprints(ctx, jiraClient, boardId, "active")
+	if err != nil {
+		return 0, err
+	}
+	for _, sprint := range sprints {
+		if sprintNameMatches(sprint) {
+			return sprint.ID, nil
+		}
+	}
+	if jiraSprintName != "" {
+		return 0, fmt.Errorf("no active sprint on board %d matches JIRA_SPRINT_NAME %q", boardId, jiraSprintName)
+	}
+	return 0, nil
+}
+
+func createIssue(ctx context.Context, jiraClient *jira.Client, commitInfo *commitInfo, project string, components []string, addToSprint bool) (*jira.Issue, error) {
+	extraFields := map[string]interface{}{}
+	if addToSprint {
+		boardId, err := resolveBoardID(ctx, jiraClient)
+		if err != nil {
+			return nil, err
+		}
+		sprint, err := sprintID(ctx, jiraClient, boardId)
+		if err != nil {
+			return nil, err
+		}
+		if sprint != 0 {
+			extraFields[jiraSprintFieldName] = sprint
+		}
+	}
+
+	if err := addRequestType(ctx, jiraClient, project, extraFields); err != nil {
+		return nil, err
+	}
+
+	description := commitInfo.Body
+	if *descriptionFromCommits {
+		var err error
+		if description, err = branchDescription(ctx, commitInfo.Rev); err != nil {
+			return nil, err
+		}
+	}
+
+	issueType := jiraIssueType
+	if jiraBranchIssueTypes != "" {
+		branchTypes := parseBranchIssueTypes(jiraBranchIssueTypes)
+		if err := validateIssueTypes(ctx, jiraClient, project, branchTypes); err != nil {
+			return nil, err
+		}
+		if t, ok := issueTypeForBranch(branchTypes, commitInfo.Branch); ok {
+			issueType = t
+		}
+	}
+
+	if *securityLevel != "" {
+		id, err := resolveSecurityLevel(ctx, jiraClient, project, issueType, *securityLevel)
+		if err != nil {
+			return nil, err
+		}
+		extraFields["security"] = map[string]string{"id": id}
+	}
+
+	i := jira.Issue{
+		Fields: &jira.IssueFields{
+			Description: description,
+			Type: jira.IssueType{
+				Name: issueType,
+			},
+			Project: jira.Project{
+				Key: project,
+			},
+			Summary:  truncateRunes(commitInfo.Title, *maxSummaryLength),
+			Unknowns: extraFields,
+		},
+	}
+	for _, c := range components {
+		i.Fields.Components = append(i.Fields.Components, &jira.Component{Name: c})
+	}
+	for _, label := range append(splitList(jiraLabels), *autoLabel) {
+		if label != "" && !containsString(i.Fields.Labels, label) {
+			i.Fields.Labels = append(i.Fields.Labels, label)
+		}
+	}
+	assignee := jiraAccountId
+	if *assigneeFlag != "" {
+		assignee = *assigneeFlag
+	}
+	if assignee != "none" && assignee != "" {
+		// leaving Assignee nil omits the field entirely, which is what JIRA wants for unassigned
+		i.Fields.Assignee = &jira.User{AccountID: assignee}
+	}
+	if jiraParentId != "" {
+		i.Fields.Parent = &jira.Parent{ID: jiraParentId}
+	}
+	if *explainFlag {
+		args := []string{"jira", "issue", "create", "--project", project, "--type", issueType, "--summary", i.Fields.Summary, "--body", description}
+		if i.Fields.Assignee != nil {
+			args = append(args, "--assignee", assignee)
+		}
+		if jiraParentId != "" {
+			args = append(args, "--parent", jiraParentId)
+		}
+		for _, c := range components {
+			args = append(args, "--component", c)
+		}
+		for _, l := range i.Fields.Labels {
+			args = append(args, "--label", l)
+		}
+		for k, v := range extraFields {
+			args = append(args, "--custom", fmt.Sprintf("%s=%v", k, v))
+		}
+		explain(args...)
+	}
+
+	if *dryRun {
+		printIssuePreview(&i, extraFields)
+		return &i, nil
+	}
+
+	issue, _, err := jiraClient.Issue.CreateWithContext(ctx, &i)
+	return issue, err
+}
+
+// printIssuePreview shows the ticket -dryRun would have created.
+func printIssuePreview(i *jira.Issue, extraFields map[string]interface{}) {
+	fmt.Println("Would create ticket:")
+	fmt.Println("  Project:", i.Fields.Project.Key)
+	fmt.Println("  Type:", i.Fields.Type.Name)
+	fmt.Println("  Summary:", i.Fields.Summary)
+	if sprint, ok := extraFields[jiraSprintFieldName]; ok {
+		fmt.Println("  Sprint:", sprint)
+	}
+	for _, c := range i.Fields.Components {
+		fmt.Println("  Component:", c.Name)
+	}
+	if len(i.Fields.Labels) > 0 {
+		fmt.Println("  Labels:", strings.Join(i.Fields.Labels, ", "))
+	}
+	if i.Fields.Assignee != nil {
+		fmt.Println("  Assignee:", i.Fields.Assignee.AccountID)
+	}
+}
+
+// truncateRunes shortens s to at most max runes, ending in an ellipsis if anything was cut.
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if max <= 0 || len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// resolveBoardID returns JIRA_BOARD_ID if it's set, and otherwise looks up the project's scrum board,
+// caching the answer so we only have to ask JIRA once.
+func resolveBoardID(ctx context.Context, jiraClient *jira.Client) (int, error) {
+	if jiraBoardID != "" {
+		return strconv.Atoi(jiraBoardID)
+	}
+	cacheKey := jiraBaseURL() + " " + jiraProjectName
+	cache := readBoardCache()
+	if id, ok := cache[cacheKey]; ok {
+		return id, nil
+	}
+	boards, _, err := jiraClient.Board.GetAllBoardsWithContext(ctx, &jira.BoardListOptions{BoardType: "scrum", ProjectKeyOrID: jiraProjectName})
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up the board for %s: %w", jiraProjectName, err)
+	}
+	switch len(boards.Values) {
+	case 0:
+		return 0, fmt.Errorf("no scrum board found for %s, set JIRA_BOARD_ID", jiraProjectName)
+	case 1:
+	default:
+		var names []string
+		for _, b := range boards.Values {
+			names = append(names, fmt.Sprintf("%d (%s)", b.ID, b.Name))
+		}
+		return 0, fmt.Errorf("%s has several scrum boards, set JIRA_BOARD_ID to one of: %s", jiraProjectName, strings.Join(names, ", "))
+	}
+	id := boards.Values[0].ID
+	cache[cacheKey] = id
+	if err := writeBoardCache(cache); err != nil {
+		warnf("failed to cache board ID: %v", err)
+	}
+	return id, nil
+}
+
+func boardCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "autopr", "boards.json"), nil
+}
+
+// readBoardCache returns the cached board IDs, keyed by JIRA URL and project. A missing or corrupt cache is just empty.
+func readBoardCache() map[string]int {
+	cache := map[string]int{}
+	path, err := boardCachePath()
+	if err != nil {
+		return cache
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(b, &cache)
+	}
+	return cache
+}
+
+func writeBoardCache(cache map[string]int) error {
+	path, err := boardCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// branchDescription describes every commit on the branch up to rev, oldest first.
+func branchDescription(ctx context.Context, rev string) (string, error) {
+	commits, err := getBranchCommits(ctx, fmt.Sprintf("origin/%s..%s", targetGithubBranch, rev))
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, c := range commits {
+		part := "* " + c.Subject
+		if c.Body != "" {
+			part += "\n" + c.Body
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// resolveSecurityLevel finds the ID of the named security level in the project's create metadata,
+// since the create API only takes IDs.
+func resolveSecurityLevel(ctx context.Context, jiraClient *jira.Client, projectKey string, issueTypeName string, name string) (string, error) {
+	meta, _, err := jiraClient.Issue.GetCreateMetaWithContext(ctx, projectKey)
+	if err != nil {
+		return "", err
+	}
+	project := meta.GetProjectWithKey(projectKey)
+	if project == nil {
+		return "", fmt.Errorf("project %s not found", projectKey)
+	}
+	issueType := project.GetIssueTypeWithName(issueTypeName)
+	if issueType == nil {
+		return "", fmt.Errorf("issue type %q not found in project %s", issueTypeName, projectKey)
+	}
+	security, _ := issueType.Fields["security"].(map[string]interface{})
+	allowed, _ := security["allowedValues"].([]interface{})
+	var ids, names []string
+	for _, v := range allowed {
+		level, _ := v.(map[string]interface{})
+		levelName, _ := level["name"].(string)
+		id, _ := level["id"].(string)
+		names = append(names, levelName)
+		if strings.EqualFold(levelName, name) {
+			ids = append(ids, id)
+		}
+	}
+	switch {
+	case len(allowed) == 0:
+		return "", fmt.Errorf("%s %ss can't have a security level", projectKey, issueTypeName)
+	case len(ids) == 0:
+		return "", fmt.Errorf("no security level named %q in %s, available: %s", name, projectKey, strings.Join(names, ", "))
+	case len(ids) > 1:
+		warnf("security level name %q is ambiguous in %s, using ID %s", name, projectKey, ids[0])
+	}
+	return ids[0], nil
+}
+
+// addRequestType sets the request type field, which Jira Service Management projects require on top of the issue type.
+func addRequestType(ctx context.Context, jiraClient *jira.Client, projectKey string, extraFields map[string]interface{}) error {
+	// go-jira's Project doesn't include the project type, so fetch it ourselves
+	req, err := jiraClient.NewRequestWithContext(ctx, "GET", "rest/api/2/project/"+projectKey, nil)
+	if err != nil {
+		return err
+	}
+	var project struct {
+		ProjectTypeKey string `json:"projectTypeKey"`
+	}
+	if _, err := jiraClient.Do(req, &project); err != nil {
+		return err
+	}
+	if project.ProjectTypeKey != "service_desk" {
+		if *requestType != "" {
+			warnf("ignoring -requestType, %s isn't a service management project", projectKey)
+		}
+		return nil
+	}
+	if *requestType == "" {
+		return fmt.Errorf("%s is a Jira Service Management project, so -requestType must be set", projectKey)
+	}
+	if jiraRequestTypeFieldName == "" {
+		return fmt.Errorf("JIRA_REQUEST_TYPE_FIELD_NAME env var must be set to use -requestType")
+	}
+	extraFields[jiraRequestTypeFieldName] = *requestType
+	return nil
+}
+
+// parseBranchIssueTypes parses a list like "bug=Bug,feat=Story" into a map of branch prefix to issue type.
+func parseBranchIssueTypes(s string) map[string]string {
+	types := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		prefix, issueType, _ := strings.Cut(pair, "=")
+		prefix, issueType = strings.TrimSpace(prefix), strings.TrimSpace(issueType)
+		if prefix == "" || issueType == "" {
+			continue
+		}
+		types[prefix] = issueType
+	}
+	return types
+}
+
+// issueTypeForBranch looks up the issue type for a branch like "bug/fix-the-thing" by its first path segment.
+func issueTypeForBranch(branchTypes map[string]string, branch string) (string, bool) {
+	prefix, _, ok := strings.Cut(branch, "/")
+	if !ok {
+		return "", false
+	}
+	issueType, ok := branchTypes[prefix]
+	return issueType, ok
+}
+
+func validateIssueTypes(ctx context.Context, jiraClient *jira.Client, projectKey string, branchTypes map[string]string) error {
+	meta, _, err := jiraClient.Issue.GetCreateMetaWithContext(ctx, projectKey)
+	if err != nil {
+		return err
+	}
+	project := meta.GetProjectWithKey(projectKey)
+	if project == nil {
+		return fmt.Errorf("project %s not found", projectKey)
+	}
+	for prefix, issueType := range branchTypes {
+		if project.GetIssueTypeWithName(issueType) == nil {
+			return fmt.Errorf("issue type %q for branch prefix %q not found in project %s", issueType, prefix, projectKey)
+		}
+	}
+	return nil
+}
+
+// transitionIssueToStarted moves a new ticket to JIRA_START_STATUS. Workflows differ, so not finding a
+// transition there is only a warning.
+func transitionIssueToStarted(ctx context.Context, jiraClient *jira.Client, issueKey string) error {
+	_, err := transitionIssueTo(ctx, jiraClient, issueKey, jiraStartStatus, transitionFields)
+	var noTransition *noTransitionError
+	if errors.As(err, &noTransition) {
+		warnf("%v", err)
+		return nil
+	}
+	return err
+}
+
+func addIssueKeyToCommit(ctx context.Context, commitInfo *commitInfo, issueKey string) error {
+	if strings.HasPrefix(commitInfo.Title, issueKey+":") {
+		// already amended on a previous run
+		return nil
+	}
+	commitInfo.Title = fmt.Sprintf("%s: %s", issueKey, commitInfo.Title)
+	if commitInfo.KeepCommit {
+		return nil
+	}
+	if commitInfo.MessageFile != "" {
+		return prefixMessageFile(commitInfo.MessageFile, commitInfo.Title)
+	}
+	// go through a file rather than -m so long messages and odd characters survive intact
+	f, err := os.CreateTemp("", "autopr-msg-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	msg := fmt.Sprintf("%s\n\n%s", commitInfo.Title, commitInfo.Body)
+	if commitInfo.HeadMessage != "" {
+		msg = fmt.Sprintf("%s: %s", issueKey, commitInfo.HeadMessage)
+	}
+	_, err = f.WriteString(msg)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	args := []string{"commit", "--amend", "-F", f.Name()}
+	env := os.Environ()
+	if *authorName != "" {
+		// --author keeps the original author date; the committer has to come from the environment
+		args = append(args, "--author", fmt.Sprintf("%s <%s>", *authorName, *authorEmail))
+		env = append(env, "GIT_COMMITTER_NAME="+*authorName, "GIT_COMMITTER_EMAIL="+*authorEmail)
+	}
+	explain(append([]string{"git"}, args...)...)
+	cmd := exec.Command("git", args...)
+	cmd.Env = env
+	return cmd.Run()
+}
+
+// highlight makes s stand out when we're writing to a terminal that wants color.
+func highlight(s string) string {
+	if !useColor() {
+		return s
+	}
+	return "\x1b[1;36m" + s + "\x1b[0m"
+}
+
+func useColor() bool {
+	if *noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if inGithubActions() {
+		fmt.Fprintln(os.Stderr, "::warning::"+escapeAnnotation(msg))
+		return
+	}
+	fmt.Fprintln(os.Stderr, "warning: "+msg)
+}
+
+// Exit codes, so hooks and CI can tell what kind of thing went wrong. Anything else exits with 1.
+const (
+	exitDirty  = 2
+	exitJira   = 3
+	exitGithub = 4
+	exitGit    = 5
+)
+
+// exitError tags an error with the exit code it should cause.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	var tagged *exitError
+	if err == nil || errors.As(err, &tagged) {
+		return err
+	}
+	return &exitError{code: code, err: err}
+}
+
+func jiraError(err error) error   { return withExitCode(exitJira, err) }
+func githubError(err error) error { return withExitCode(exitGithub, err) }
+func gitError(err error) error    { return withExitCode(exitGit, err) }
+
+// fatal prints err to stderr and exits with its exit code, as an error annotation when running in GitHub Actions.
+func fatal(err error) {
+	if inGithubActions() {
+		fmt.Fprintln(os.Stderr, "::error::"+escapeAnnotation(err.Error()))
+	} else {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+	}
+	code := 1
+	var tagged *exitError
+	if errors.As(err, &tagged) {
+		code = tagged.code
+	}
+	os.Exit(code)
+}
+
+func inGithubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// escapeAnnotation escapes a message for a workflow command, which otherwise ends at the first newline.
+func escapeAnnotation(msg string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(msg)
+}
+
+// explain prints a shell command equivalent to what we're about to do, when -explain is set.
+func explain(args ...string) {
+	if !*explainFlag {
+		return
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	fmt.Println("$", strings.Join(quoted, " "))
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated setting, dropping blanks.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 func stringPtr(s string) *string { return &s }
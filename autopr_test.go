@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// chdir changes directory for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// gitRepo makes an empty git repo in a temporary directory and changes into it for the rest of the test.
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	chdir(t, dir)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"config", "user.name", "Test"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "commit.gpgsign", "false"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	return dir
+}
+
+func TestLimitReviewers(t *testing.T) {
+	tests := []struct {
+		name        string
+		explicit    []string
+		teams       []string
+		derived     []string
+		max         int
+		wantKept    []string
+		wantTeams   []string
+		wantDropped []string
+	}{
+		{
+			name:     "under the limit",
+			explicit: []string{"alice"},
+			derived:  []string{"bob"},
+			max:      15,
+			wantKept: []string{"alice", "bob"},
+		},
+		{
+			name:        "explicit reviewers are kept before derived ones",
+			explicit:    []string{"alice", "bob"},
+			derived:     []string{"carol", "dave"},
+			max:         3,
+			wantKept:    []string{"alice", "bob", "carol"},
+			wantDropped: []string{"dave"},
+		},
+		{
+			name:        "derived reviewers are the first to go",
+			explicit:    []string{"alice", "bob"},
+			derived:     []string{"carol"},
+			max:         2,
+			wantKept:    []string{"alice", "bob"},
+			wantDropped: []string{"carol"},
+		},
+		{
+			name:        "too many explicit reviewers",
+			explicit:    []string{"alice", "bob", "carol"},
+			derived:     []string{"dave"},
+			max:         2,
+			wantKept:    []string{"alice", "bob"},
+			wantDropped: []string{"carol", "dave"},
+		},
+		{
+			name:     "duplicates are dropped ignoring case, keeping the first spelling",
+			explicit: []string{"Alice", "bob"},
+			derived:  []string{"alice", "BOB", "carol"},
+			max:      3,
+			wantKept: []string{"Alice", "bob", "carol"},
+		},
+		{
+			name:     "a duplicate doesn't use up a slot",
+			explicit: []string{"alice"},
+			derived:  []string{"ALICE", "bob"},
+			max:      2,
+			wantKept: []string{"alice", "bob"},
+		},
+		{
+			name:     "blanks are skipped",
+			explicit: []string{"", "alice"},
+			derived:  []string{""},
+			max:      1,
+			wantKept: []string{"alice"},
+		},
+		{
+			name:     "zero means no limit",
+			explicit: []string{"alice", "bob"},
+			derived:  []string{"carol"},
+			max:      0,
+			wantKept: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:     "negative means no limit",
+			explicit: []string{"alice"},
+			derived:  []string{"bob"},
+			max:      -1,
+			wantKept: []string{"alice", "bob"},
+		},
+		{
+			name:        "teams count toward the limit",
+			explicit:    []string{"alice"},
+			teams:       []string{"backend"},
+			derived:     []string{"bob"},
+			max:         2,
+			wantKept:    []string{"alice"},
+			wantTeams:   []string{"backend"},
+			wantDropped: []string{"bob"},
+		},
+		{
+			name:        "explicit users come before teams",
+			explicit:    []string{"alice", "bob"},
+			teams:       []string{"backend", "infra"},
+			max:         3,
+			wantKept:    []string{"alice", "bob"},
+			wantTeams:   []string{"backend"},
+			wantDropped: []string{"infra"},
+		},
+		{
+			name:      "a team can share a user's name",
+			explicit:  []string{"infra"},
+			teams:     []string{"infra", "INFRA"},
+			max:       15,
+			wantKept:  []string{"infra"},
+			wantTeams: []string{"infra"},
+		},
+		{
+			name: "nobody",
+			max:  15,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, teams, dropped := limitReviewers(tt.explicit, tt.teams, tt.derived, tt.max)
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept %q, want %q", kept, tt.wantKept)
+			}
+			if !reflect.DeepEqual(teams, tt.wantTeams) {
+				t.Errorf("kept teams %q, want %q", teams, tt.wantTeams)
+			}
+			if !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("dropped %q, want %q", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestParseBranchIssueTypes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{"", map[string]string{}},
+		{"bug=Bug", map[string]string{"bug": "Bug"}},
+		{"bug=Bug,feat=Story", map[string]string{"bug": "Bug", "feat": "Story"}},
+		{" bug = Bug , feat=Story ", map[string]string{"bug": "Bug", "feat": "Story"}},
+		{"chore=Technical Task", map[string]string{"chore": "Technical Task"}},
+		{"bug=Bug,,feat=Story,", map[string]string{"bug": "Bug", "feat": "Story"}},
+		{"bug,feat=Story", map[string]string{"feat": "Story"}},
+		{"=Bug,feat=,feat2=Story", map[string]string{"feat2": "Story"}},
+		{"bug=Bug,bug=Defect", map[string]string{"bug": "Defect"}},
+	}
+	for _, tt := range tests {
+		if got := parseBranchIssueTypes(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseBranchIssueTypes(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIssueTypeForBranch(t *testing.T) {
+	types := map[string]string{"bug": "Bug", "feat": "Story"}
+	tests := []struct {
+		branch string
+		want   string
+		wantOK bool
+	}{
+		{"bug/fix-the-thing", "Bug", true},
+		{"feat/new/nested", "Story", true},
+		{"bug", "", false},
+		{"bugfix-no-slash", "", false},
+		{"docs/readme", "", false},
+		{"Bug/case-matters", "", false},
+		{"/leading-slash", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := issueTypeForBranch(types, tt.branch)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("issueTypeForBranch(%q) = %q, %v, want %q, %v", tt.branch, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestAddIssueKeyToCommitAlreadyPrefixed(t *testing.T) {
+	// none of these should touch git, so run them outside a repo where amending would fail
+	chdir(t, t.TempDir())
+	tests := []struct {
+		name string
+		info commitInfo
+		want string
+	}{
+		{"already prefixed", commitInfo{Title: "ABC-12: Fix the thing"}, "ABC-12: Fix the thing"},
+		{"already prefixed and kept", commitInfo{Title: "ABC-12: Fix the thing", KeepCommit: true}, "ABC-12: Fix the thing"},
+		{"kept", commitInfo{Title: "Fix the thing", KeepCommit: true}, "ABC-12: Fix the thing"},
+		{"another key's prefix is kept", commitInfo{Title: "ABC-1: Fix the thing", KeepCommit: true}, "ABC-12: ABC-1: Fix the thing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := tt.info
+			if err := addIssueKeyToCommit(context.Background(), &info, "ABC-12"); err != nil {
+				t.Fatal(err)
+			}
+			if info.Title != tt.want {
+				t.Errorf("title %q, want %q", info.Title, tt.want)
+			}
+		})
+	}
+}
+
+func TestBranchIgnorePatterns(t *testing.T) {
+	ignoreFile := "# release branches are cut by CI\nrelease/*\n\n  wip/*  \n#hotfix/*\n"
+	want := []string{"main", "deploy-*", "release/*", "wip/*"}
+	if got := branchIgnorePatterns(" main, deploy-* ,", ignoreFile); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := branchIgnorePatterns("", ""); len(got) != 0 {
+		t.Errorf("got %q from nothing", got)
+	}
+}
+
+func TestMatchBranchPattern(t *testing.T) {
+	tests := []struct {
+		branch   string
+		patterns []string
+		want     string
+		wantErr  bool
+	}{
+		{"release/1.2", []string{"release/*"}, "release/*", false},
+		{"release/1.2/hotfix", []string{"release/*"}, "", false},
+		{"release", []string{"release/*"}, "", false},
+		{"wip/try-this", []string{"release/*", "wip/*"}, "wip/*", false},
+		{"feature/wip/x", []string{"wip/*"}, "", false},
+		{"main", []string{"*"}, "*", false},
+		{"feature/x", []string{"*"}, "", false},
+		{"feature/x", []string{"*/*"}, "*/*", false},
+		{"deploy-prod", []string{"deploy-*", "*"}, "deploy-*", false},
+		{"main", nil, "", false},
+		{"main", []string{"[main"}, "", true},
+		{"main", []string{"main", "[main"}, "main", false},
+	}
+	for _, tt := range tests {
+		got, err := matchBranchPattern(tt.branch, tt.patterns)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("matchBranchPattern(%q, %q) error %v, want error %v", tt.branch, tt.patterns, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("matchBranchPattern(%q, %q) = %q, want %q", tt.branch, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+// fakeJira serves just enough of the JIRA API for createIssue, and records the fields of created issues.
+// Searches find one open ticket, PLAT-7, summarized "Fix the thing".
+func fakeJira(t *testing.T) (*jira.Client, *[]map[string]interface{}) {
+	t.Helper()
+	var created []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/rest/api/2/project/PLAT":
+			w.Write([]byte(`{"key":"PLAT","projectTypeKey":"software"}`))
+		case r.Method == "GET" && r.URL.Path == "/rest/api/2/search":
+			w.Write([]byte(`{"total":1,"issues":[{"key":"PLAT-7","fields":{"summary":"Fix the thing"}}]}`))
+		case r.Method == "POST" && r.URL.Path == "/rest/api/2/issue":
+			var req struct {
+				Fields map[string]interface{} `json:"fields"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("bad create request: %v", err)
+			}
+			created = append(created, req.Fields)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"10001","key":"PLAT-1"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client, err := jira.NewClient(nil, srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &created
+}
+
+func TestCreateIssueAssignee(t *testing.T) {
+	oldAccountID, oldAssignee := jiraAccountId, *assigneeFlag
+	t.Cleanup(func() { jiraAccountId, *assigneeFlag = oldAccountID, oldAssignee })
+	tests := []struct {
+		name      string
+		accountID string
+		flag      string
+		want      string // "" for no assignee field at all
+	}{
+		{"from JIRA_ACCOUNT_ID", "me", "", "me"},
+		{"from -assignee", "me", "you", "you"},
+		{"-assignee none", "me", "none", ""},
+		{"nobody configured", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jiraAccountId, *assigneeFlag = tt.accountID, tt.flag
+			client, created := fakeJira(t)
+			if _, err := createIssue(context.Background(), client, &commitInfo{Title: "Fix the thing"}, "PLAT", nil, false); err != nil {
+				t.Fatal(err)
+			}
+			if len(*created) != 1 {
+				t.Fatalf("created %d issues, want 1", len(*created))
+			}
+			assignee, ok := (*created)[0]["assignee"]
+			if tt.want == "" {
+				if ok {
+					t.Errorf("assignee is %v, want the field left out", assignee)
+				}
+			} else if user, _ := assignee.(map[string]interface{}); user["accountId"] != tt.want {
+				t.Errorf("assignee is %v, want account %s", assignee, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateIssueReportsReuse(t *testing.T) {
+	oldProject, oldDedupe := jiraProjectName, *dedupeTickets
+	t.Cleanup(func() { jiraProjectName, *dedupeTickets = oldProject, oldDedupe })
+	jiraProjectName = "PLAT"
+	tests := []struct {
+		dedupe      bool
+		wantKey     string
+		wantCreated bool
+	}{
+		{false, "PLAT-1", true},
+		{true, "PLAT-7", false},
+	}
+	for _, tt := range tests {
+		*dedupeTickets = tt.dedupe
+		client, created := fakeJira(t)
+		key, isNew, err := (&jiraTracker{client: client}).CreateIssue(context.Background(), &commitInfo{Title: "Fix the thing"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != tt.wantKey || isNew != tt.wantCreated {
+			t.Errorf("-dedupeTickets=%v: got %s, created=%v, want %s, created=%v", tt.dedupe, key, isNew, tt.wantKey, tt.wantCreated)
+		}
+		if tt.wantCreated != (len(*created) == 1) {
+			t.Errorf("-dedupeTickets=%v: created %d issues", tt.dedupe, len(*created))
+		}
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"one over", 7, "one ov…"},
+		{"日本語のタイトル", 8, "日本語のタイトル"},
+		{"日本語のタイトル", 7, "日本語のタイ…"},
+		{"日本語のタイトルです", 5, "日本語の…"},
+		{"émoji 🎉🎉🎉", 7, "émoji …"},
+		{"anything", 1, "…"},
+		{"anything", 0, "anything"},
+		{"anything", -1, "anything"},
+		{"", 5, ""},
+	}
+	for _, tt := range tests {
+		got := truncateRunes(tt.in, tt.max)
+		if got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+		if tt.max > 0 && len([]rune(got)) > tt.max {
+			t.Errorf("truncateRunes(%q, %d) is %d runes", tt.in, tt.max, len([]rune(got)))
+		}
+	}
+}
+
+// gitCommit makes a new repo with one commit with exactly the message msg.
+func gitCommit(t *testing.T, msg string) {
+	t.Helper()
+	gitRepo(t)
+	if err := os.WriteFile("file.txt", []byte("content\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", "file.txt"}, {"commit", "-q", "--cleanup=verbatim", "-m", msg}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+}
+
+func TestAddIssueKeyToCommitAmends(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{
+			name: "quotes and shell characters",
+			msg:  "Handle \"quoted\" and 'single' names\n\nDon't expand $HOME, `date` or $(whoami); keep \\n and 100% literal.",
+			want: "ABC-12: Handle \"quoted\" and 'single' names\n\nDon't expand $HOME, `date` or $(whoami); keep \\n and 100% literal.",
+		},
+		{
+			name: "unicode",
+			msg:  "Unterstützung für Umlaute — 日本語 🎉\n\nZweiter Absatz mit « Anführungszeichen ».",
+			want: "ABC-12: Unterstützung für Umlaute — 日本語 🎉\n\nZweiter Absatz mit « Anführungszeichen ».",
+		},
+		{
+			name: "markdown headings and several paragraphs",
+			msg:  "Add the thing\n\n# Why\nBecause.\n\n# How\n- carefully\n- -m would have split this",
+			want: "ABC-12: Add the thing\n\n# Why\nBecause.\n\n# How\n- carefully\n- -m would have split this",
+		},
+		{
+			name: "title only",
+			msg:  "Just a title with \"quotes\"",
+			want: "ABC-12: Just a title with \"quotes\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitCommit(t, tt.msg)
+			title, body := splitCommitMessage(tt.msg)
+			info := &commitInfo{Title: title, Body: body}
+			if err := addIssueKeyToCommit(context.Background(), info, "ABC-12"); err != nil {
+				t.Fatal(err)
+			}
+			out, err := exec.Command("git", "log", "-1", "--format=%B").Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimRight(string(out), "\n"); got != tt.want {
+				t.Errorf("amended message is\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddIssueKeyToCommitKeepsHeadMessage(t *testing.T) {
+	msg := "Last commit on the branch\n\nWith \"its own\" body — ünïcödé."
+	gitCommit(t, msg)
+	// a multi-commit summary amends HEAD's own message, not the summary
+	info := &commitInfo{Title: "First commit on the branch", Body: "- First commit on the branch\n- Last commit on the branch", HeadMessage: msg}
+	if err := addIssueKeyToCommit(context.Background(), info, "ABC-12"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("git", "log", "-1", "--format=%B").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimRight(string(out), "\n"), "ABC-12: "+msg; got != want {
+		t.Errorf("amended message is\n%s\nwant\n%s", got, want)
+	}
+	if want := "ABC-12: First commit on the branch"; info.Title != want {
+		t.Errorf("title %q, want %q", info.Title, want)
+	}
+}
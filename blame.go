@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v37/github"
+)
+
+var shaPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,\d+)? @@`)
+
+// blameCounts blames the lines changed between base and rev (as they were on the base) and counts them
+// by author email, remembering one commit per author so we can find their GitHub login later.
+func blameCounts(ctx context.Context, base string, rev string) (map[string]int, map[string]string, error) {
+	out, err := exec.Command("git", "diff", "-U0", "--no-color", base+"..."+rev).Output()
+	if err != nil {
+		return nil, nil, err
+	}
+	counts := map[string]int{}
+	commits := map[string]string{}
+	var file string
+	// a removed line starting with "-- " also looks like "--- ", so only take file names from the header
+	// between "diff --git" and the first hunk
+	inHeader := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			file, inHeader = "", true
+			continue
+		}
+		if inHeader && strings.HasPrefix(line, "--- ") {
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+			continue
+		}
+		m := hunkHeader.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		inHeader = false
+		if file == "" || file == "/dev/null" {
+			continue
+		}
+		start, _ := strconv.Atoi(m[1])
+		length := 1
+		if m[2] != "" {
+			length, _ = strconv.Atoi(m[2])
+		}
+		if length == 0 {
+			// pure addition, nothing on the base to blame
+			continue
+		}
+		blame, err := exec.Command("git", "blame", "--line-porcelain", "-L", fmt.Sprintf("%d,+%d", start, length), base, "--", file).Output()
+		if err != nil {
+			continue
+		}
+		var sha string
+		for _, bl := range strings.Split(string(blame), "\n") {
+			if strings.HasPrefix(bl, "\t") {
+				// the line's content
+				continue
+			}
+			if fields := strings.Fields(bl); len(fields) >= 3 && shaPattern.MatchString(fields[0]) {
+				sha = fields[0]
+			} else if strings.HasPrefix(bl, "author-mail ") {
+				email := strings.Trim(strings.TrimPrefix(bl, "author-mail "), "<>")
+				counts[email]++
+				commits[email] = sha
+			}
+		}
+	}
+	return counts, commits, nil
+}
+
+// blameReviewer finds the GitHub user who wrote most of the code this branch changes, skipping
+// the PR author and bots. It returns "" if there's no suitable candidate.
+func blameReviewer(ctx context.Context, githubClient *github.Client, rev string) (string, error) {
+	counts, commits, err := blameCounts(ctx, "origin/"+targetGithubBranch, rev)
+	if err != nil {
+		return "", err
+	}
+	me, _, err := githubClient.Users.Get(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	emails := make([]string, 0, len(counts))
+	for email := range counts {
+		emails = append(emails, email)
+	}
+	sort.Slice(emails, func(i, j int) bool { return counts[emails[i]] > counts[emails[j]] })
+	for _, email := range emails {
+		if strings.Contains(email, "[bot]") {
+			continue
+		}
+		commit, _, err := githubClient.Repositories.GetCommit(ctx, targetGithubOrg, targetGithubRepo, commits[email])
+		if err != nil {
+			continue
+		}
+		author := commit.GetAuthor()
+		if author == nil || author.GetLogin() == me.GetLogin() || author.GetType() == "Bot" || strings.HasSuffix(author.GetLogin(), "[bot]") {
+			continue
+		}
+		return author.GetLogin(), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults can be kept in config files instead of the environment: a per-user one at
+// $XDG_CONFIG_HOME/autopr/config.yml (or ~/.config/autopr/config.yml), and a per-repo one at
+// .autopr.yml in the repo root. Keys are either environment variable names or flag names:
+//
+//	JIRA_ACCOUNT_ID: 5b10a2844c20165700ede21g
+//	JIRA_PROJECT_NAME: PLAT
+//	addToCurrentSprint: true
+//
+// Precedence is flags > environment > repo config > user config > built-in defaults. The repo config is
+// usually committed, so secrets (GITHUB_TOKEN, JIRA_TOKEN) are only read from the environment and the user config.
+
+type fileConfig struct {
+	Settings map[string]string `yaml:",inline"`
+}
+
+var settings, userSettings, settingsErr = loadSettings()
+
+// settings that must never come from the repo config
+var secretSettings = map[string]bool{"GITHUB_TOKEN": true, "JIRA_TOKEN": true}
+
+// envSettings records every environment setting we read, so -validateConfig knows which keys are real.
+var envSettings = map[string]bool{}
+
+// getenv is os.Getenv with the config files as a fallback.
+func getenv(key string) string {
+	envSettings[key] = true
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return settings[key]
+}
+
+// getsecret is os.Getenv with only the user config as a fallback.
+func getsecret(key string) string {
+	envSettings[key] = true
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return userSettings[key]
+}
+
+// getenvDefault is getenv, but returns def if the setting isn't there at all.
+func getenvDefault(key string, def string) string {
+	envSettings[key] = true
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	if v, ok := settings[key]; ok {
+		return v
+	}
+	return def
+}
+
+func userConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "autopr", "config.yml")
+}
+
+func repoConfigPath() string {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(strings.TrimSpace(string(out)), ".autopr.yml")
+}
+
+// loadSettings merges the user config and then the repo config on top of it. It also returns the user
+// config on its own, for secrets.
+func loadSettings() (map[string]string, map[string]string, error) {
+	merged := map[string]string{}
+	user := map[string]string{}
+	userPath := userConfigPath()
+	for _, path := range []string{userPath, repoConfigPath()} {
+		if path == "" {
+			continue
+		}
+		cfg, err := readConfigFile(path)
+		if err != nil {
+			return merged, user, err
+		}
+		for k, v := range cfg.Settings {
+			merged[k] = v
+			if path == userPath {
+				user[k] = v
+			}
+		}
+	}
+	return merged, user, nil
+}
+
+func readConfigFile(path string) (*fileConfig, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &fileConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cfg fileConfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// applyConfigToFlags sets any flag in fs that wasn't passed on the command line from the config files.
+// It must be called after fs is parsed.
+func applyConfigToFlags(fs *flag.FlagSet) error {
+	passed := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := settings[f.Name]
+		if !ok || passed[f.Name] || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(v); setErr != nil {
+			err = fmt.Errorf("bad config value for %s: %w", f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// applyBranchConfig lets individual branches target a different base or repo, set with e.g.
+//
+//	git config branch.my-feature.autopr-base release-1.2
+//	git config branch.my-feature.autopr-repo otherorg/otherrepo
+//
+// These override the environment and config files, but not -base.
+func applyBranchConfig() error {
+	branchName, err := currentBranch()
+	if err != nil {
+		return err
+	}
+	if base := gitConfig("branch." + branchName + ".autopr-base"); base != "" {
+		targetGithubBranch = base
+	}
+	if repo := gitConfig("branch." + branchName + ".autopr-repo"); repo != "" {
+		if org, name, ok := strings.Cut(repo, "/"); ok {
+			targetGithubOrg, targetGithubRepo = org, name
+		} else {
+			targetGithubRepo = repo
+		}
+	}
+	if *baseFlag != "" {
+		targetGithubBranch = *baseFlag
+	}
+	return nil
+}
+
+// gitOutput runs git and returns its stdout, with git's complaint in the error if it fails. It's a
+// variable so tests can fake git.
+var gitOutput = func(args ...string) ([]byte, error) {
+	out, err := exec.Command("git", args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(bytes.TrimSpace(exitErr.Stderr)) > 0 {
+		err = fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(exitErr.Stderr))
+	}
+	return out, err
+}
+
+// gitConfig returns a git config value, or "" if it isn't set.
+func gitConfig(key string) string {
+	out, err := gitOutput("config", "--get", key)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
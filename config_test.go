@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withConfigFiles writes user and repo config files, pointing XDG_CONFIG_HOME and the repo root at
+// temporary directories, and loads them as the settings for the rest of the test.
+func withConfigFiles(t *testing.T, user string, repo string) {
+	t.Helper()
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	if err := os.MkdirAll(filepath.Join(xdg, "autopr"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(xdg, "autopr", "config.yml"), []byte(user), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := gitRepo(t)
+	if err := os.WriteFile(filepath.Join(dir, ".autopr.yml"), []byte(repo), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldSettings, oldUserSettings := settings, userSettings
+	t.Cleanup(func() { settings, userSettings = oldSettings, oldUserSettings })
+	var err error
+	if settings, userSettings, err = loadSettings(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	withConfigFiles(t,
+		"AUTOPR_TEST_USER_ONLY: user\nAUTOPR_TEST_BOTH: user\nAUTOPR_TEST_ENV: user\n",
+		"AUTOPR_TEST_REPO_ONLY: repo\nAUTOPR_TEST_BOTH: repo\nAUTOPR_TEST_ENV: repo\n")
+	t.Setenv("AUTOPR_TEST_ENV", "env")
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"AUTOPR_TEST_USER_ONLY", "user"},
+		{"AUTOPR_TEST_REPO_ONLY", "repo"},
+		{"AUTOPR_TEST_BOTH", "repo"},
+		{"AUTOPR_TEST_ENV", "env"},
+		{"AUTOPR_TEST_UNSET", ""},
+	}
+	for _, tt := range tests {
+		if got := getenv(tt.key); got != tt.want {
+			t.Errorf("getenv(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+	if got := getenvDefault("AUTOPR_TEST_UNSET", "default"); got != "default" {
+		t.Errorf("getenvDefault of an unset key = %q, want the default", got)
+	}
+	if got := getenvDefault("AUTOPR_TEST_USER_ONLY", "default"); got != "user" {
+		t.Errorf("getenvDefault of a user config key = %q, want %q", got, "user")
+	}
+}
+
+func TestConfigEmptyEnvBeatsConfig(t *testing.T) {
+	withConfigFiles(t, "AUTOPR_TEST_KEY: user\n", "AUTOPR_TEST_KEY: repo\n")
+	t.Setenv("AUTOPR_TEST_KEY", "")
+	if got := getenv("AUTOPR_TEST_KEY"); got != "" {
+		t.Errorf("getenv = %q, want the empty env var to win", got)
+	}
+}
+
+func TestConfigSecretsIgnoreRepoConfig(t *testing.T) {
+	withConfigFiles(t, "AUTOPR_TEST_SECRET: user\n", "AUTOPR_TEST_SECRET: repo\nAUTOPR_TEST_REPO_SECRET: repo\n")
+	if got := getsecret("AUTOPR_TEST_SECRET"); got != "user" {
+		t.Errorf("getsecret = %q, want the user config's value", got)
+	}
+	if got := getsecret("AUTOPR_TEST_REPO_SECRET"); got != "" {
+		t.Errorf("getsecret = %q, want nothing from the repo config", got)
+	}
+	t.Setenv("AUTOPR_TEST_SECRET", "env")
+	if got := getsecret("AUTOPR_TEST_SECRET"); got != "env" {
+		t.Errorf("getsecret = %q, want the env var", got)
+	}
+}
+
+func TestApplyConfigToFlags(t *testing.T) {
+	withConfigFiles(t,
+		"mode: user\nlimit: 10\nverbose: true\n",
+		"mode: repo\nname: repo\n")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	mode := fs.String("mode", "default", "")
+	name := fs.String("name", "default", "")
+	limit := fs.Int("limit", 1, "")
+	verbose := fs.Bool("verbose", false, "")
+	untouched := fs.String("untouched", "default", "")
+	if err := fs.Parse([]string{"-name", "flag"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigToFlags(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *mode != "repo" {
+		t.Errorf("mode = %q, want the repo config to beat the user config", *mode)
+	}
+	if *name != "flag" {
+		t.Errorf("name = %q, want the command line to beat the config", *name)
+	}
+	if *limit != 10 || !*verbose {
+		t.Errorf("limit = %d, verbose = %v, want the user config's values", *limit, *verbose)
+	}
+	if *untouched != "default" {
+		t.Errorf("untouched = %q, want the default", *untouched)
+	}
+}
+
+func TestApplyConfigToFlagsBadValue(t *testing.T) {
+	withConfigFiles(t, "", "limit: lots\n")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("limit", 1, "")
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigToFlags(fs); err == nil {
+		t.Error("expected an error for a non-numeric value")
+	}
+}
+
+// fakeGit replaces git for the rest of the test with one that's on branch and has the given config.
+func fakeGit(t *testing.T, branch string, config map[string]string) {
+	t.Helper()
+	old := gitOutput
+	t.Cleanup(func() { gitOutput = old })
+	gitOutput = func(args ...string) ([]byte, error) {
+		switch {
+		case reflect.DeepEqual(args, []string{"rev-parse", "--abbrev-ref", "HEAD"}):
+			return []byte(branch + "\n"), nil
+		case len(args) == 3 && args[0] == "config" && args[1] == "--get":
+			if v, ok := config[args[2]]; ok {
+				return []byte(v + "\n"), nil
+			}
+			return nil, errors.New("exit status 1")
+		}
+		t.Fatalf("unexpected git %v", args)
+		return nil, nil
+	}
+}
+
+func TestApplyBranchConfig(t *testing.T) {
+	oldBranch, oldOrg, oldRepo, oldBase := targetGithubBranch, targetGithubOrg, targetGithubRepo, *baseFlag
+	t.Cleanup(func() {
+		targetGithubBranch, targetGithubOrg, targetGithubRepo, *baseFlag = oldBranch, oldOrg, oldRepo, oldBase
+	})
+	tests := []struct {
+		name       string
+		config     map[string]string
+		base       string
+		wantBranch string
+		wantOrg    string
+		wantRepo   string
+	}{
+		{
+			name:       "nothing set keeps the env",
+			wantBranch: "main", wantOrg: "envorg", wantRepo: "envrepo",
+		},
+		{
+			name:       "branch config beats the env",
+			config:     map[string]string{"branch.feature.autopr-base": "release-1.2"},
+			wantBranch: "release-1.2", wantOrg: "envorg", wantRepo: "envrepo",
+		},
+		{
+			name:       "-base beats the branch config",
+			config:     map[string]string{"branch.feature.autopr-base": "release-1.2"},
+			base:       "develop",
+			wantBranch: "develop", wantOrg: "envorg", wantRepo: "envrepo",
+		},
+		{
+			name:       "-base beats the env",
+			base:       "develop",
+			wantBranch: "develop", wantOrg: "envorg", wantRepo: "envrepo",
+		},
+		{
+			name:       "other branches' config is ignored",
+			config:     map[string]string{"branch.other.autopr-base": "release-1.2", "branch.other.autopr-repo": "o/r"},
+			wantBranch: "main", wantOrg: "envorg", wantRepo: "envrepo",
+		},
+		{
+			name:       "repo with an org",
+			config:     map[string]string{"branch.feature.autopr-repo": "otherorg/otherrepo"},
+			wantBranch: "main", wantOrg: "otherorg", wantRepo: "otherrepo",
+		},
+		{
+			name:       "repo without an org keeps the env org",
+			config:     map[string]string{"branch.feature.autopr-repo": "otherrepo"},
+			wantBranch: "main", wantOrg: "envorg", wantRepo: "otherrepo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetGithubBranch, targetGithubOrg, targetGithubRepo, *baseFlag = "main", "envorg", "envrepo", tt.base
+			fakeGit(t, "feature", tt.config)
+			if err := applyBranchConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if targetGithubBranch != tt.wantBranch || targetGithubOrg != tt.wantOrg || targetGithubRepo != tt.wantRepo {
+				t.Errorf("got %s/%s@%s, want %s/%s@%s", targetGithubOrg, targetGithubRepo, targetGithubBranch, tt.wantOrg, tt.wantRepo, tt.wantBranch)
+			}
+		})
+	}
+}
+
+func TestGitOutputIncludesStderr(t *testing.T) {
+	chdir(t, t.TempDir())
+	_, err := currentBranch()
+	if err == nil || !strings.Contains(err.Error(), "not a git repository") {
+		t.Errorf("got %v, want git's complaint in the error", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// findDuplicateTickets looks for open tickets that were already filed for this commit, e.g. by an earlier
+// run that failed after creating its ticket. Only an exact summary match in the same project counts, since
+// linking the wrong tickets is worse than leaving a duplicate around. If there's more than one, the newer
+// ones are linked as duplicates of the oldest. It returns the oldest ticket's key, or "" if there are none.
+func findDuplicateTickets(ctx context.Context, jiraClient *jira.Client, commitInfo *commitInfo) (string, error) {
+	summary := truncateRunes(commitInfo.Title, *maxSummaryLength)
+	jql := fmt.Sprintf(`project = %q AND statusCategory != Done AND summary ~ %q ORDER BY created ASC`, jiraProjectName, `"`+summary+`"`)
+	issues, _, err := jiraClient.Issue.SearchWithContext(ctx, jql, &jira.SearchOptions{Fields: []string{"summary", "created"}, MaxResults: 50})
+	if err != nil {
+		return "", fmt.Errorf("failed to search for duplicate tickets: %w", err)
+	}
+	var matches []string
+	for _, issue := range issues {
+		// the search is fuzzy, so check the summary really is the same
+		if strings.TrimSpace(issue.Fields.Summary) == strings.TrimSpace(summary) {
+			matches = append(matches, issue.Key)
+		} else {
+			fmt.Printf("Not treating %s as a duplicate, its summary differs: %q\n", issue.Key, issue.Fields.Summary)
+		}
+	}
+	if len(matches) == 0 {
+		fmt.Println("No existing tickets found for this commit")
+		return "", nil
+	}
+	canonical := matches[0]
+	fmt.Printf("Using existing ticket %s (the oldest open one with this summary)\n", canonical)
+	for _, key := range matches[1:] {
+		if *dryRun {
+			fmt.Printf("Would link %s as a duplicate of %s\n", key, canonical)
+			continue
+		}
+		link := &jira.IssueLink{
+			Type: jira.IssueLinkType{Name: "Duplicate"},
+			// reads as "<outward> duplicates <inward>"
+			InwardIssue:  &jira.Issue{Key: canonical},
+			OutwardIssue: &jira.Issue{Key: key},
+		}
+		if _, err := jiraClient.Issue.AddLinkWithContext(ctx, link); err != nil {
+			warnf("failed to link %s as a duplicate of %s: %v", key, canonical, err)
+		} else {
+			fmt.Printf("Linked %s as a duplicate of %s\n", key, canonical)
+		}
+	}
+	return canonical, nil
+}
@@ -6,6 +6,7 @@ require (
 	github.com/andygrunwald/go-jira v1.13.0
 	github.com/google/go-github/v37 v37.0.0
 	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/google/go-github/v37/github"
+	"gopkg.in/yaml.v3"
+)
+
+// A -labelRules file is a YAML list of regexes to match against the commit title and body, and the
+// labels to put on the PR when they match:
+//
+//	- pattern: (?i)migration
+//	  labels: [db-migration]
+//	- pattern: ^Revert
+//	  labels: [revert]
+
+type labelRule struct {
+	Pattern string   `yaml:"pattern"`
+	Labels  []string `yaml:"labels"`
+	re      *regexp.Regexp
+}
+
+// loadLabelRules reads and compiles a rules file. An empty path means no rules.
+func loadLabelRules(path string) ([]labelRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []labelRule
+	if err := yaml.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i := range rules {
+		if rules[i].re, err = regexp.Compile(rules[i].Pattern); err != nil {
+			return nil, fmt.Errorf("bad pattern in %s: %w", path, err)
+		}
+	}
+	return rules, nil
+}
+
+// matchLabels returns the labels of every rule that matches the title or body, without repeats.
+func matchLabels(rules []labelRule, commitInfo *commitInfo) []string {
+	var labels []string
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if !rule.re.MatchString(commitInfo.Title) && !rule.re.MatchString(commitInfo.Body) {
+			continue
+		}
+		for _, label := range rule.Labels {
+			if !seen[label] {
+				seen[label] = true
+				labels = append(labels, label)
+			}
+		}
+	}
+	return labels
+}
+
+// addLabels adds labels to the PR, skipping any repeats.
+func addLabels(ctx context.Context, githubClient *github.Client, prNumber int, labels []string) {
+	var unique []string
+	seen := map[string]bool{}
+	for _, label := range labels {
+		if !seen[label] {
+			seen[label] = true
+			unique = append(unique, label)
+		}
+	}
+	if len(unique) == 0 {
+		return
+	}
+	if _, _, err := githubClient.Issues.AddLabelsToIssue(ctx, targetGithubOrg, targetGithubRepo, prNumber, unique); err != nil {
+		warnf("failed to add labels %v: %v", unique, err)
+	} else if *verbose {
+		fmt.Println("Added labels", unique)
+	}
+}
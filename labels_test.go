@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeLabelRules(t *testing.T, rules string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "labels.yml")
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLabelRules(t *testing.T) {
+	rules, err := loadLabelRules(writeLabelRules(t, "- pattern: (?i)migration\n  labels: [db-migration]\n- pattern: ^Revert\n  labels: [revert, needs-qa]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Pattern != "(?i)migration" || !reflect.DeepEqual(rules[1].Labels, []string{"revert", "needs-qa"}) {
+		t.Errorf("got %+v", rules)
+	}
+	for _, r := range rules {
+		if r.re == nil {
+			t.Errorf("rule %q wasn't compiled", r.Pattern)
+		}
+	}
+}
+
+func TestLoadLabelRulesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{"bad YAML", "- pattern: [unclosed\n"},
+		{"not a list", "pattern: x\nlabels: [y]\n"},
+		{"bad regex", "- pattern: (unclosed\n  labels: [x]\n"},
+		{"bad regex after a good one", "- pattern: ok\n  labels: [x]\n- pattern: a{2,1}\n  labels: [y]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadLabelRules(writeLabelRules(t, tt.rules)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+	if _, err := loadLabelRules(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestLoadLabelRulesNoPath(t *testing.T) {
+	rules, err := loadLabelRules("")
+	if err != nil || rules != nil {
+		t.Errorf("got %v, %v, want no rules", rules, err)
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	rules, err := loadLabelRules(writeLabelRules(t, `
+- pattern: (?i)migration
+  labels: [db-migration]
+- pattern: ^Revert
+  labels: [revert, needs-qa]
+- pattern: (?m)^BREAKING CHANGE
+  labels: [breaking, needs-qa]
+- pattern: schema
+  labels: [db-migration]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		title string
+		body  string
+		want  []string
+	}{
+		{"no match", "Fix a typo", "", nil},
+		{"title match", "Add Migration for users", "", []string{"db-migration"}},
+		{"body match", "Add users table", "Includes a migration.", []string{"db-migration"}},
+		{"anchored pattern only matches the title's start", "Fix Revert button", "", nil},
+		{"anchored pattern checks the body separately", "Fix the build", "Revert of the last change.", []string{"revert", "needs-qa"}},
+		{"multiline body", "Drop the v1 API", "Some context.\n\nBREAKING CHANGE: v1 is gone", []string{"breaking", "needs-qa"}},
+		{"several rules, in rule order, without repeats", "Revert the migration", "BREAKING CHANGE: schema", []string{"db-migration", "revert", "needs-qa", "breaking"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchLabels(rules, &commitInfo{Title: tt.title, Body: tt.body}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v37/github"
+)
+
+// A privilegedAction is an opt-in step that needs write access to the target repo.
+type privilegedAction struct {
+	name    string
+	enabled func() bool
+	disable func()
+}
+
+var privilegedActions = []privilegedAction{
+	{
+		name:    "request reviewers (-blameReviewers)",
+		enabled: func() bool { return *blameReviewers },
+		disable: func() { *blameReviewers = false },
+	},
+	{
+		name:    "request reviewers (PR_REVIEWERS, PR_TEAM_REVIEWERS)",
+		enabled: func() bool { return prReviewers != "" || prTeamReviewers != "" },
+		disable: func() { prReviewers, prTeamReviewers = "", "" },
+	},
+	{
+		name:    "add labels (PR_LABELS)",
+		enabled: func() bool { return prLabels != "" },
+		disable: func() { prLabels = "" },
+	},
+	{
+		name:    "add labels (-deleteBranchHintLabel)",
+		enabled: func() bool { return *deleteBranchHint && *deleteBranchHintLabel != "" },
+		disable: func() { *deleteBranchHint = false },
+	},
+	{
+		name:    "add labels (-labelRules)",
+		enabled: func() bool { return *labelRules != "" },
+		disable: func() { *labelRules = "" },
+	},
+	{
+		name:    "create deployments (-recordDeployment)",
+		enabled: func() bool { return *recordDeployment != "" },
+		disable: func() { *recordDeployment = "" },
+	},
+}
+
+// checkPermissions turns off requested actions that the token's user doesn't have permission for,
+// warning about each one up front rather than failing halfway through the run. If we can't tell
+// what the user's permission is, we leave everything on and let the individual calls fail.
+func checkPermissions(ctx context.Context, githubClient *github.Client) {
+	var requested []privilegedAction
+	for _, a := range privilegedActions {
+		if a.enabled() {
+			requested = append(requested, a)
+		}
+	}
+	if len(requested) == 0 {
+		return
+	}
+	me, _, err := githubClient.Users.Get(ctx, "")
+	if err != nil {
+		warnf("couldn't check repo permissions: %v", err)
+		return
+	}
+	level, _, err := githubClient.Repositories.GetPermissionLevel(ctx, targetGithubOrg, targetGithubRepo, me.GetLogin())
+	if err != nil {
+		warnf("couldn't check repo permissions: %v", err)
+		return
+	}
+	permission := level.GetPermission()
+	canWrite := permission == "write" || permission == "admin"
+	if *verbose {
+		fmt.Printf("%s has %s access to %s/%s\n", me.GetLogin(), permission, targetGithubOrg, targetGithubRepo)
+	}
+	for _, a := range requested {
+		if canWrite {
+			if *verbose {
+				fmt.Printf("  can %s\n", a.name)
+			}
+			continue
+		}
+		warnf("%s has %s access to %s/%s, so won't %s", me.GetLogin(), permission, targetGithubOrg, targetGithubRepo, a.name)
+		a.disable()
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/google/go-github/v37/github"
+)
+
+// runRelease implements "autopr release": tag HEAD, file a release ticket, and open a PR from the
+// current (release) branch to the base with a changelog as its body. If anything fails after the
+// tag is created, the tag is removed again so the release can be retried.
+func runRelease(ctx context.Context, githubClient *github.Client, tracker IssueTracker, args []string) error {
+	fs := flag.NewFlagSet("release", flag.ExitOnError)
+	tag := fs.String("tag", "", "name of the annotated tag to create, e.g. v1.4.0")
+	notesFrom := fs.String("notesFrom", "", "ref to build the changelog from (default: the previous tag, or the base branch if there isn't one)")
+	fs.Parse(args)
+	if *tag == "" {
+		return fmt.Errorf("release: -tag is required")
+	}
+	if err := checkDirty(*dirtyMode); err != nil {
+		return gitError(err)
+	}
+	branchName, err := currentBranch()
+	if err != nil {
+		return gitError(err)
+	}
+	from := *notesFrom
+	if from == "" {
+		if out, err := exec.Command("git", "describe", "--tags", "--abbrev=0", "HEAD").Output(); err == nil {
+			from = strings.TrimSpace(string(out))
+		} else {
+			from = "origin/" + targetGithubBranch
+		}
+	}
+	commits, err := getBranchCommits(ctx, from+"..HEAD")
+	if err != nil {
+		return gitError(err)
+	}
+	var changelog []string
+	for _, c := range commits {
+		changelog = append(changelog, "- "+c.Subject)
+	}
+	info := &commitInfo{
+		Branch:     branchName,
+		Rev:        "HEAD",
+		Title:      "Release " + *tag,
+		Body:       fmt.Sprintf("Changes since %s:\n\n%s", from, strings.Join(changelog, "\n")),
+		KeepCommit: true,
+	}
+
+	issueKey, created, err := tracker.CreateIssue(ctx, info)
+	if err != nil {
+		return jiraError(err)
+	}
+	if issueKey != "" {
+		outcome = append(outcome, ticketOutcome(issueKey, created))
+		fmt.Println("Ticket:", highlight(issueKey))
+		info.Title = fmt.Sprintf("%s: %s", issueKey, info.Title)
+	}
+
+	explain("git", "tag", "-a", *tag, "-m", info.Title)
+	if out, err := exec.Command("git", "tag", "-a", *tag, "-m", info.Title).CombinedOutput(); err != nil {
+		return gitError(fmt.Errorf("failed to create tag %s: %w\n%s", *tag, err, out))
+	}
+	pushed := false
+	rollback := func(cause error) error {
+		exec.Command("git", "tag", "-d", *tag).Run()
+		if pushed {
+			exec.Command("git", "push", "origin", ":refs/tags/"+*tag).Run()
+		}
+		if issueKey != "" {
+			return fmt.Errorf("%w (removed tag %s; release ticket %s was left open)", cause, *tag, issueKey)
+		}
+		return fmt.Errorf("%w (removed tag %s)", cause, *tag)
+	}
+
+	if err := forcePushBranch(ctx, branchName); err != nil {
+		return rollback(gitError(err))
+	}
+	explain("git", "push", "origin", "refs/tags/"+*tag)
+	if err := exec.Command("git", "push", "origin", "refs/tags/"+*tag).Run(); err != nil {
+		return rollback(gitError(fmt.Errorf("failed to push tag %s: %w", *tag, err)))
+	}
+	pushed = true
+	pr, created, err := createPR(ctx, githubClient, info)
+	if err != nil {
+		return rollback(githubError(err))
+	}
+	outcome = append(outcome, "tagged "+*tag, "pushed "+branchName)
+	outcome = append(outcome, prOutcome(pr, created))
+	fmt.Println("PR:", highlight(pr.GetHTMLURL()))
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/go-github/v37/github"
+)
+
+// A splitResult is what "autopr split" made for one commit.
+type splitResult struct {
+	Subject  string
+	Branch   string
+	IssueKey string
+	PRURL    string
+}
+
+// runSplit implements "autopr split": open a separate ticket and PR for each commit on the branch, to break
+// a big branch into reviewable pieces. By default the PRs are stacked, each one based on the previous
+// commit's branch. With -independent each commit is cherry-picked onto the base instead, so the PRs can be
+// merged in any order (as long as the commits really are independent). The commits themselves aren't
+// changed; issue keys only go in the PR titles. The per-commit branches are only kept on the remote.
+func runSplit(ctx context.Context, githubClient *github.Client, tracker IssueTracker, args []string) (err error) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	independent := fs.Bool("independent", false, "base every PR on the base branch, cherry-picking each commit onto it, instead of stacking them")
+	fs.Parse(args)
+	if !*independent && !strings.EqualFold(sourceGithubOrg, targetGithubOrg) {
+		// GitHub can only base a PR on a branch in the target repo
+		return fmt.Errorf("split: stacked PRs need the branches pushed to the target repo, use -independent when working from a fork")
+	}
+	branchName, err := currentBranch()
+	if err != nil {
+		return gitError(err)
+	}
+	commits, err := getBranchCommits(ctx, fmt.Sprintf("origin/%s..HEAD", targetGithubBranch))
+	if err != nil {
+		return gitError(err)
+	}
+	if len(commits) == 0 {
+		fmt.Println("No commits to split")
+		return nil
+	}
+
+	var results []splitResult
+	var pushed []string
+	report := func() {
+		for _, r := range results {
+			fmt.Printf("%s\n  branch: %s\n", r.Subject, r.Branch)
+			if r.IssueKey != "" {
+				fmt.Printf("  ticket: %s\n", r.IssueKey)
+			}
+			if r.PRURL != "" {
+				fmt.Printf("  PR: %s\n", r.PRURL)
+			}
+		}
+	}
+	// report what was made even if we stop partway, so nothing gets orphaned
+	defer func() {
+		if err != nil && len(results) > 0 {
+			fmt.Println("Created before failing:")
+			report()
+		}
+		if err == nil || len(pushed) == 0 {
+			return
+		}
+		if !*assumeYes && !confirm(fmt.Sprintf("Delete the branches pushed to %s (this closes their PRs)?", pushRemote)) {
+			fmt.Printf("Left %s on %s\n", strings.Join(pushed, ", "), pushRemote)
+			return
+		}
+		for _, b := range pushed {
+			explain("git", "push", pushRemote, "--delete", b)
+			if out, err := exec.Command("git", "push", pushRemote, "--delete", b).CombinedOutput(); err != nil {
+				warnf("failed to delete %s from %s: %v\n%s", b, pushRemote, err, out)
+			}
+		}
+	}()
+
+	base := targetGithubBranch
+	defer func() { targetGithubBranch = base }()
+	prevBranch := base
+	for _, c := range commits {
+		info := &commitInfo{
+			Branch:     fmt.Sprintf("%s-%s", branchName, c.SHA[:8]),
+			Rev:        c.SHA,
+			Title:      c.Subject,
+			Body:       c.Body,
+			KeepCommit: true,
+		}
+		result := splitResult{Subject: c.Subject, Branch: info.Branch, IssueKey: issueKeyPattern.FindString(c.Subject)}
+		if result.IssueKey == "" {
+			var created bool
+			if result.IssueKey, created, err = tracker.CreateIssue(ctx, info); err != nil {
+				return jiraError(err)
+			}
+			if result.IssueKey != "" {
+				outcome = append(outcome, ticketOutcome(result.IssueKey, created))
+			}
+		}
+		results = append(results, result)
+		if result.IssueKey != "" && !strings.HasPrefix(info.Title, result.IssueKey) {
+			if err := tracker.Transition(ctx, result.IssueKey); err != nil {
+				return jiraError(err)
+			}
+			info.Title = fmt.Sprintf("%s: %s", result.IssueKey, info.Title)
+		}
+
+		if *independent {
+			if err := cherryPickBranch(info.Branch, c.SHA, "origin/"+base); err != nil {
+				return gitError(err)
+			}
+		} else {
+			explain("git", "branch", "-f", info.Branch, c.SHA)
+			if out, err := exec.Command("git", "branch", "-f", info.Branch, c.SHA).CombinedOutput(); err != nil {
+				return gitError(fmt.Errorf("failed to create branch %s: %w\n%s", info.Branch, err, out))
+			}
+		}
+		pushErr := forcePushBranch(ctx, info.Branch)
+		// the branch is only needed locally to push it, so don't leave one behind for every commit
+		deleteLocalBranch(info.Branch)
+		if pushErr != nil {
+			return gitError(fmt.Errorf("failed to push %s: %w", info.Branch, pushErr))
+		}
+		pushed = append(pushed, info.Branch)
+
+		targetGithubBranch = prevBranch
+		pr, created, err := createPR(ctx, githubClient, info)
+		if err != nil {
+			return githubError(fmt.Errorf("failed to create PR for %s: %w", info.Branch, err))
+		}
+		outcome = append(outcome, prOutcome(pr, created))
+		results[len(results)-1].PRURL = pr.GetHTMLURL()
+		if *independent {
+			prevBranch = base
+		} else {
+			prevBranch = info.Branch
+		}
+	}
+	report()
+	return nil
+}
+
+func deleteLocalBranch(branch string) {
+	explain("git", "branch", "-D", branch)
+	if out, err := exec.Command("git", "branch", "-D", branch).CombinedOutput(); err != nil {
+		warnf("failed to delete the local branch %s: %v\n%s", branch, err, out)
+	}
+}
+
+// cherryPickBranch points branch at commit cherry-picked onto base. It works in a temporary worktree so
+// the user's checkout is left alone, and removes it afterwards.
+func cherryPickBranch(branch string, commit string, base string) error {
+	dir, err := os.MkdirTemp("", "autopr-split-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		exec.Command("git", "worktree", "remove", "--force", dir).Run()
+		os.RemoveAll(dir)
+	}()
+	if out, err := exec.Command("git", "worktree", "add", "--detach", dir, base).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to check out %s: %w\n%s", base, err, out)
+	}
+	pick := exec.Command("git", "cherry-pick", "--allow-empty", commit)
+	pick.Dir = dir
+	if out, err := pick.CombinedOutput(); err != nil {
+		exec.Command("git", "-C", dir, "cherry-pick", "--abort").Run()
+		return fmt.Errorf("%s doesn't apply cleanly to %s on its own, try without -independent: %w\n%s", commit[:8], base, err, out)
+	}
+	explain("git", "branch", "-f", branch, commit+" (cherry-picked onto "+base+")")
+	if out, err := exec.Command("git", "-C", dir, "branch", "-f", branch, "HEAD").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create branch %s: %w\n%s", branch, err, out)
+	}
+	return nil
+}
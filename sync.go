@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/v37/github"
+)
+
+// comma-separated PR state to JIRA status pairs for "autopr sync"
+var jiraSyncStatusMap = getenv("JIRA_SYNC_STATUS_MAP")
+
+// Status names are matched case-insensitively. Since instances in other languages have localized
+// status names, JIRA_STATUS_ALIASES can map the English names used in config to what the instance
+// calls them, e.g. "In Progress=En cours|In Bearbeitung,Done=Terminé".
+var jiraStatusAliases = getenv("JIRA_STATUS_ALIASES")
+
+// set with -transitionField, for workflows whose transitions need e.g. a resolution
+var transitionFields = keyValueFlag{}
+
+func init() {
+	flag.Var(transitionFields, "transitionField", "`field=value` to set when moving a ticket, e.g. resolution=Done or comment=...; can be repeated")
+}
+
+// keyValueFlag collects repeated key=value flags.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	var pairs []string
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("%q isn't field=value", s)
+	}
+	f[strings.TrimSpace(k)] = v
+	return nil
+}
+
+const defaultSyncStatusMap = "open=In Review,merged=Done,closed=Won't Do"
+
+var issueKeyPattern = regexp.MustCompile(`^[A-Z]+-\d+`)
+
+// runSync implements "autopr sync": move the branch's ticket to the status matching its PR's state.
+// It's meant to run from CI on PR events, so it's a no-op when the ticket is already there.
+func runSync(ctx context.Context, githubClient *github.Client, tracker IssueTracker, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	branch := fs.String("branch", "", "branch whose PR and ticket to sync (default: the current branch)")
+	fs.Parse(args)
+	jt, ok := tracker.(*jiraTracker)
+	if !ok {
+		return fmt.Errorf("sync only works with -tracker jira")
+	}
+	branchName := *branch
+	if branchName == "" {
+		var err error
+		if branchName, err = currentBranch(); err != nil {
+			return gitError(err)
+		}
+	}
+	prs, _, err := githubClient.PullRequests.List(ctx, targetGithubOrg, targetGithubRepo, &github.PullRequestListOptions{
+		State:     "all",
+		Head:      fmt.Sprintf("%s:%s", sourceGithubOrg, branchName),
+		Sort:      "updated",
+		Direction: "desc",
+	})
+	if err != nil {
+		return githubError(err)
+	}
+	if len(prs) == 0 {
+		return fmt.Errorf("no PR found for %s", branchName)
+	}
+	pr := prs[0]
+	// autopr puts the key in the PR title, which still works once the PR is merged and its commits are on the base
+	issueKey := titleIssueKey(pr.GetTitle())
+	if issueKey == "" {
+		if issueKey, err = branchIssueKey(ctx, branchName); err != nil {
+			return gitError(err)
+		}
+	}
+	if issueKey == "" {
+		return fmt.Errorf("no issue key found in the title of PR #%d or the commits on %s", pr.GetNumber(), branchName)
+	}
+	state := pr.GetState()
+	if pr.MergedAt != nil {
+		state = "merged"
+	}
+	statusMap := jiraSyncStatusMap
+	if statusMap == "" {
+		statusMap = defaultSyncStatusMap
+	}
+	status, ok := parseKeyValueList(statusMap)[state]
+	if !ok {
+		fmt.Printf("PR #%d is %s, which has no mapped status, nothing to do\n", pr.GetNumber(), state)
+		outcome = append(outcome, fmt.Sprintf("left %s alone (PR #%d is %s)", issueKey, pr.GetNumber(), state))
+		return nil
+	}
+	changed, err := transitionIssueTo(ctx, jt.client, issueKey, status, transitionFields)
+	if err != nil {
+		return jiraError(err)
+	}
+	if changed {
+		fmt.Printf("Moved %s to %s (PR #%d is %s)\n", highlight(issueKey), status, pr.GetNumber(), state)
+		outcome = append(outcome, fmt.Sprintf("moved %s to %s", issueKey, status))
+	} else {
+		fmt.Printf("%s is already %s\n", highlight(issueKey), status)
+		outcome = append(outcome, fmt.Sprintf("%s already %s", issueKey, status))
+	}
+	return nil
+}
+
+var titleStylePrefix = regexp.MustCompile(`^(\[[^\]]*\])+\s*`)
+
+// titleIssueKey finds the issue key at the start of a PR title, after any -prTitleStyle prefix like "[Bug][api]".
+func titleIssueKey(title string) string {
+	return issueKeyPattern.FindString(titleStylePrefix.ReplaceAllString(title, ""))
+}
+
+// branchIssueKey finds the issue key from the first commit on branch that has one.
+func branchIssueKey(ctx context.Context, branch string) (string, error) {
+	commits, err := getBranchCommits(ctx, fmt.Sprintf("origin/%s..%s", targetGithubBranch, branch))
+	if err != nil {
+		return "", err
+	}
+	for _, c := range commits {
+		if key := issueKeyPattern.FindString(c.Subject); key != "" {
+			return key, nil
+		}
+	}
+	return "", nil
+}
+
+// parseKeyValueList parses a list like "a=b,c=d".
+func parseKeyValueList(s string) map[string]string {
+	m := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if ok {
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return m
+}
+
+// statusMatches reports whether a status name from JIRA is the status we want, ignoring case and
+// accepting any of the localized aliases from JIRA_STATUS_ALIASES.
+func statusMatches(want string, got string) bool {
+	if strings.EqualFold(want, got) {
+		return true
+	}
+	for name, aliases := range parseKeyValueList(jiraStatusAliases) {
+		if !strings.EqualFold(name, want) {
+			continue
+		}
+		for _, alias := range strings.Split(aliases, "|") {
+			if strings.EqualFold(strings.TrimSpace(alias), got) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transitionIssueTo moves an issue to the named status via whichever transition leads there.
+// It returns false without doing anything if the issue is already there.
+func transitionIssueTo(ctx context.Context, jiraClient *jira.Client, issueKey string, status string, fields map[string]string) (bool, error) {
+	issue, _, err := jiraClient.Issue.GetWithContext(ctx, issueKey, &jira.GetQueryOptions{Fields: "status"})
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch %s: %w", issueKey, err)
+	}
+	if issue.Fields != nil && issue.Fields.Status != nil && statusMatches(status, issue.Fields.Status.Name) {
+		return false, nil
+	}
+	transitions, _, err := jiraClient.Issue.GetTransitionsWithContext(ctx, issueKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to get transitions for %s: %w", issueKey, err)
+	}
+	var available []string
+	for _, t := range transitions {
+		if statusMatches(status, t.To.Name) {
+			payload, err := transitionPayload(t, fields)
+			if err != nil {
+				return false, fmt.Errorf("can't move %s to %s: %w", issueKey, status, err)
+			}
+			if _, err := jiraClient.Issue.DoTransitionWithPayloadWithContext(ctx, issueKey, payload); err != nil {
+				return false, fmt.Errorf("failed to move %s to %s: %w", issueKey, status, err)
+			}
+			return true, nil
+		}
+		available = append(available, t.To.Name)
+	}
+	return false, &noTransitionError{issueKey: issueKey, status: status, available: available}
+}
+
+// transitionPayload builds the request for a transition, filling in whichever of the -transitionField
+// values are on its screen (sending others would make JIRA reject it) and checking its required fields
+// are all there. A "comment" is always added to the transition. Resolution and priority are set by name.
+func transitionPayload(t jira.Transition, values map[string]string) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	var missing []string
+	for id, f := range t.Fields {
+		v, ok := values[id]
+		if !ok {
+			if f.Required {
+				missing = append(missing, id)
+			}
+			continue
+		}
+		switch id {
+		case "resolution", "priority":
+			fields[id] = map[string]string{"name": v}
+		default:
+			fields[id] = v
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("the %q transition requires %s; set them with -transitionField", t.Name, strings.Join(missing, ", "))
+	}
+	payload := map[string]interface{}{"transition": map[string]string{"id": t.ID}}
+	if len(fields) > 0 {
+		payload["fields"] = fields
+	}
+	if comment := values["comment"]; comment != "" {
+		payload["update"] = map[string]interface{}{
+			"comment": []interface{}{map[string]interface{}{"add": map[string]string{"body": comment}}},
+		}
+	}
+	return payload, nil
+}
+
+// noTransitionError means the ticket's workflow has no way to get to the status we wanted from where it is.
+type noTransitionError struct {
+	issueKey  string
+	status    string
+	available []string
+}
+
+func (e *noTransitionError) Error() string {
+	return fmt.Sprintf("%s has no transition to %q, available: %s", e.issueKey, e.status, strings.Join(e.available, ", "))
+}
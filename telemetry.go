@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is a deliberately tiny OTLP/HTTP JSON trace exporter: enough to see how long each step of a run
+// takes in an existing tracing backend, without pulling the whole OpenTelemetry SDK into a CLI tool.
+// Everything is a no-op unless OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set.
+
+var otlpEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+var otlpTracesEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+var otlpHeaders = os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")
+
+type span struct {
+	name     string
+	traceID  string
+	spanID   string
+	parentID string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+}
+
+type spanContextKey struct{}
+
+var finishedSpans struct {
+	sync.Mutex
+	spans []*span
+}
+
+func tracingEnabled() bool {
+	return otlpEndpoint != "" || otlpTracesEndpoint != ""
+}
+
+// startSpan starts a span as a child of any span in ctx. The returned span is nil when tracing is disabled;
+// all span methods are safe to call on nil.
+func startSpan(ctx context.Context, name string, attrs ...string) (context.Context, *span) {
+	if !tracingEnabled() {
+		return ctx, nil
+	}
+	s := &span{name: name, spanID: randomHex(8), start: time.Now(), attrs: map[string]string{}}
+	if parent, ok := ctx.Value(spanContextKey{}).(*span); ok {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomHex(16)
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[attrs[i]] = attrs[i+1]
+	}
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+func (s *span) SetAttribute(key string, value string) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// End finishes the span, marking it as failed if err is non-nil.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	finishedSpans.Lock()
+	finishedSpans.spans = append(finishedSpans.spans, s)
+	finishedSpans.Unlock()
+}
+
+// flushSpans sends all finished spans to the OTLP endpoint. Export failures are only warnings:
+// tracing should never fail a run.
+func flushSpans(ctx context.Context) {
+	if !tracingEnabled() {
+		return
+	}
+	finishedSpans.Lock()
+	spans := finishedSpans.spans
+	finishedSpans.spans = nil
+	finishedSpans.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := exportSpans(ctx, spans); err != nil {
+		warnf("failed to export trace: %v", err)
+	}
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	var out []otlpAttribute
+	for k, v := range attrs {
+		a := otlpAttribute{Key: k}
+		a.Value.StringValue = v
+		out = append(out, a)
+	}
+	return out
+}
+
+func exportSpans(ctx context.Context, spans []*span) error {
+	var otlpSpans []otlpSpan
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attrs),
+		}
+		if s.err != nil {
+			o.Status.Code = 2 // STATUS_CODE_ERROR
+			o.Status.Message = s.err.Error()
+		}
+		otlpSpans = append(otlpSpans, o)
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": "autopr"}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "autopr"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	endpoint := otlpTracesEndpoint
+	if endpoint == "" {
+		endpoint = strings.TrimSuffix(otlpEndpoint, "/") + "/v1/traces"
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range strings.Split(otlpHeaders, ",") {
+		if k, v, ok := strings.Cut(header, "="); ok {
+			req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// extensions that share a template with another one
+var languageAliases = map[string]string{
+	"tsx":  "ts",
+	"jsx":  "js",
+	"mjs":  "js",
+	"yml":  "yaml",
+	"hpp":  "cpp",
+	"cc":   "cpp",
+	"h":    "c",
+	"pyi":  "py",
+	"mdx":  "md",
+	"kts":  "kt",
+	"scss": "css",
+}
+
+// bodyTemplatePath is the PR template to use. With -autoTemplate, a diff that's mostly one language uses
+// <templateDir>/<language>.md (e.g. go.md) if it exists; otherwise it's -bodyTemplate.
+func bodyTemplatePath(rev string) string {
+	if !*autoTemplate {
+		return *bodyTemplate
+	}
+	out, err := exec.Command("git", "diff", "--name-only", "origin/"+targetGithubBranch+"..."+rev).Output()
+	if err != nil {
+		warnf("-autoTemplate: can't list changed files, using %s: %v", *bodyTemplate, err)
+		return *bodyTemplate
+	}
+	lang := dominantLanguage(strings.Fields(string(out)))
+	if lang == "" {
+		return *bodyTemplate
+	}
+	path := filepath.Join(*templateDir, lang+".md")
+	if _, err := os.Stat(path); err != nil {
+		return *bodyTemplate
+	}
+	if *verbose {
+		fmt.Printf("Using %s since most of the change is %s\n", path, lang)
+	}
+	return path
+}
+
+// dominantLanguage returns the language (by file extension) of more than half the files, or "" if no
+// language is a majority.
+func dominantLanguage(files []string) string {
+	counts := map[string]int{}
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		if ext == filepath.Base(file) {
+			// a dotfile like .gitignore, which has no extension
+			ext = ""
+		}
+		ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+		if alias, ok := languageAliases[ext]; ok {
+			ext = alias
+		}
+		counts[ext]++
+	}
+	for lang, n := range counts {
+		if lang != "" && n*2 > len(files) {
+			return lang
+		}
+	}
+	return ""
+}
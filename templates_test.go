@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestDominantLanguage(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"empty", nil, ""},
+		{"one file", []string{"main.go"}, "go"},
+		{"all one language", []string{"a.go", "b/c.go"}, "go"},
+		{"majority", []string{"a.go", "b.go", "README.md"}, "go"},
+		{"exact half isn't a majority", []string{"a.go", "b.go", "c.py", "d.py"}, ""},
+		{"exact half against several others", []string{"a.go", "b.go", "c.py", "d.md"}, ""},
+		{"no majority", []string{"a.go", "b.py", "c.md"}, ""},
+		{"aliases count together", []string{"a.tsx", "b.ts", "c.css"}, "ts"},
+		{"alias alone", []string{"component.tsx"}, "ts"},
+		{"extensions are case-insensitive", []string{"A.GO", "b.go", "c.py"}, "go"},
+		{"only the last extension counts", []string{"a.test.js", "b.min.js", "c.go"}, "js"},
+		{"files without an extension count against the majority", []string{"Makefile", "Dockerfile", "a.go"}, ""},
+		{"files without an extension are never the language", []string{"Makefile", "Dockerfile"}, ""},
+		{"dotfiles have no extension", []string{".gitignore", ".gitignore", "a.go"}, ""},
+		{"directories with dots don't count", []string{"v1.2/Makefile", "a.go", "b.go"}, "go"},
+	}
+	for _, tt := range tests {
+		if got := dominantLanguage(tt.files); got != tt.want {
+			t.Errorf("%s: dominantLanguage(%q) = %q, want %q", tt.name, tt.files, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// IssueTracker is the set of ticket operations autopr performs. JIRA is the
+// default; other trackers can be plugged in by adding them to trackers, or
+// without changing autopr with -tracker custom and TRACKER_CMD.
+type IssueTracker interface {
+	// CreateIssue files a ticket for the commit and returns its key, or "" if
+	// the tracker doesn't create tickets. created is false if it found an
+	// existing ticket to use instead (e.g. with -dedupeTickets).
+	CreateIssue(ctx context.Context, commitInfo *commitInfo) (key string, created bool, err error)
+	AddComment(ctx context.Context, issueKey string, body string) error
+	// Transition moves the ticket into its "work has started" state.
+	Transition(ctx context.Context, issueKey string) error
+	// Link attaches a URL (e.g. the PR) to the ticket.
+	Link(ctx context.Context, issueKey string, url string, title string) error
+}
+
+var trackers = map[string]func(ctx context.Context) (IssueTracker, error){
+	"jira":   newJiraTracker,
+	"none":   func(context.Context) (IssueTracker, error) { return noneTracker{}, nil },
+	"custom": newCustomTracker,
+}
+
+// shell command implementing -tracker custom
+var trackerCmd = getenv("TRACKER_CMD")
+
+func newTracker(ctx context.Context, name string) (IssueTracker, error) {
+	newFn, ok := trackers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tracker %q, must be one of: %s", name, strings.Join(trackerNames(), ", "))
+	}
+	return newFn(ctx)
+}
+
+func trackerNames() []string {
+	var names []string
+	for n := range trackers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type jiraTracker struct {
+	client *jira.Client
+}
+
+func newJiraTracker(ctx context.Context) (IssueTracker, error) {
+	if jiraToken == "" {
+		return nil, fmt.Errorf("JIRA_TOKEN env var must be set")
+	}
+	tp := jira.BasicAuthTransport{
+		Username: jiraUsername,
+		Password: jiraToken,
+	}
+	if *locale != "" {
+		tp.Transport = &localeTransport{locale: *locale, base: http.DefaultTransport}
+	}
+	client, err := jira.NewClient(tp.Client(), jiraAPIBase())
+	if err != nil {
+		return nil, err
+	}
+	return &jiraTracker{client: client}, nil
+}
+
+func (t *jiraTracker) CreateIssue(ctx context.Context, commitInfo *commitInfo) (string, bool, error) {
+	if *rankBefore != "" && *rankAfter != "" {
+		return "", false, fmt.Errorf("only one of -rankBefore and -rankAfter can be set")
+	}
+	rankRef := *rankBefore + *rankAfter
+	if rankRef != "" {
+		if _, _, err := t.client.Issue.GetWithContext(ctx, rankRef, nil); err != nil {
+			return "", false, fmt.Errorf("can't rank relative to %s: %w", rankRef, err)
+		}
+	}
+	if *dedupeTickets {
+		if key, err := findDuplicateTickets(ctx, t.client, commitInfo); err != nil {
+			warnf("%v", err)
+		} else if key != "" {
+			return key, false, nil
+		}
+	}
+	if *splitByArea {
+		keys, err := createAreaIssues(ctx, t.client, commitInfo)
+		if err != nil {
+			return "", false, err
+		}
+		if len(keys) > 0 {
+			if rankRef != "" && !*dryRun {
+				if err := rankIssues(ctx, t.client, keys, *rankBefore, *rankAfter); err != nil {
+					warnf("created %s but failed to rank them relative to %s: %v", strings.Join(keys, ", "), rankRef, err)
+				}
+			}
+			return keys[0], true, nil
+		}
+	}
+	issue, err := createIssue(ctx, t.client, commitInfo, jiraProjectName, nil, *addToCurrentSprintFlag || *addToNextSprintFlag)
+	if err != nil {
+		return "", false, err
+	}
+	if *dryRun {
+		return "", false, nil
+	}
+	if rankRef != "" {
+		if err := rankIssues(ctx, t.client, []string{issue.Key}, *rankBefore, *rankAfter); err != nil {
+			warnf("created %s but failed to rank it relative to %s: %v", issue.Key, rankRef, err)
+		}
+	}
+	return issue.Key, true, nil
+}
+
+func (t *jiraTracker) AddComment(ctx context.Context, issueKey string, body string) error {
+	_, _, err := t.client.Issue.AddCommentWithContext(ctx, issueKey, &jira.Comment{Body: body})
+	return err
+}
+
+func (t *jiraTracker) Transition(ctx context.Context, issueKey string) error {
+	return transitionIssueToStarted(ctx, t.client, issueKey)
+}
+
+func (t *jiraTracker) Link(ctx context.Context, issueKey string, url string, title string) error {
+	_, _, err := t.client.Issue.AddRemoteLinkWithContext(ctx, issueKey, &jira.RemoteLink{
+		Object: &jira.RemoteLinkObject{URL: url, Title: title},
+	})
+	return err
+}
+
+// jiraBaseURL is JIRA_URL without trailing slashes, with https:// added if it was left off
+// (e.g. "example.atlassian.net").
+func jiraBaseURL() string {
+	return normalizeJiraURL(jiraUrl)
+}
+
+func normalizeJiraURL(jiraURL string) string {
+	base := strings.TrimRight(strings.TrimSpace(jiraURL), "/")
+	if base != "" && !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return base
+}
+
+// jiraAPIBase is the URL API paths like "rest/api/2/..." are relative to. It ends in a slash so that any
+// context path (e.g. https://example.com/jira/) is kept.
+func jiraAPIBase() string {
+	return jiraBaseURL() + "/"
+}
+
+// jiraBrowseURL links to a ticket in the JIRA UI.
+func jiraBrowseURL(key string) string {
+	return jiraBaseURL() + "/browse/" + key
+}
+
+// localeTransport asks JIRA to localize names (statuses, transitions, errors) into a particular language.
+type localeTransport struct {
+	locale string
+	base   http.RoundTripper
+}
+
+func (t *localeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Language", t.locale)
+	return t.base.RoundTrip(req)
+}
+
+// rankIssues positions issueKeys, in order, before or after another issue using the Agile rank endpoint.
+func rankIssues(ctx context.Context, client *jira.Client, issueKeys []string, before string, after string) error {
+	payload := map[string]interface{}{"issues": issueKeys}
+	if before != "" {
+		payload["rankBeforeIssue"] = before
+	} else {
+		payload["rankAfterIssue"] = after
+	}
+	req, err := client.NewRequestWithContext(ctx, "PUT", "rest/agile/1.0/issue/rank", payload)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(req, nil)
+	return err
+}
+
+// noneTracker is for repos that don't track work in a ticketing system: commits go straight to a PR.
+type noneTracker struct{}
+
+func (noneTracker) CreateIssue(context.Context, *commitInfo) (string, bool, error) {
+	return "", false, nil
+}
+func (noneTracker) AddComment(context.Context, string, string) error   { return nil }
+func (noneTracker) Transition(context.Context, string) error           { return nil }
+func (noneTracker) Link(context.Context, string, string, string) error { return nil }
+
+// customTracker hands each operation to TRACKER_CMD, for trackers autopr doesn't know about. The command
+// is run with the operation (create, comment, transition or link) as its argument and the details as a
+// JSON object on stdin. For create it gets the commit info and prints the new issue key, or nothing if it
+// didn't make one; for the others it gets the issueKey and, as relevant, the comment body or the link's
+// url and title.
+type customTracker struct {
+	command string
+}
+
+func newCustomTracker(ctx context.Context) (IssueTracker, error) {
+	if trackerCmd == "" {
+		return nil, fmt.Errorf("TRACKER_CMD env var must be set to use -tracker custom")
+	}
+	return &customTracker{command: trackerCmd}, nil
+}
+
+func (t *customTracker) CreateIssue(ctx context.Context, commitInfo *commitInfo) (string, bool, error) {
+	if *dryRun {
+		fmt.Printf("Would create a ticket with TRACKER_CMD for %q\n", commitInfo.Title)
+		return "", false, nil
+	}
+	out, err := t.run(ctx, "create", commitInfo)
+	key := strings.TrimSpace(out)
+	return key, key != "", err
+}
+
+func (t *customTracker) AddComment(ctx context.Context, issueKey string, body string) error {
+	_, err := t.run(ctx, "comment", map[string]string{"issueKey": issueKey, "body": body})
+	return err
+}
+
+func (t *customTracker) Transition(ctx context.Context, issueKey string) error {
+	_, err := t.run(ctx, "transition", map[string]string{"issueKey": issueKey})
+	return err
+}
+
+func (t *customTracker) Link(ctx context.Context, issueKey string, url string, title string) error {
+	_, err := t.run(ctx, "link", map[string]string{"issueKey": issueKey, "url": url, "title": title})
+	return err
+}
+
+func (t *customTracker) run(ctx context.Context, op string, input interface{}) (string, error) {
+	in, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", t.command+` "$@"`, "sh", op)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("TRACKER_CMD %s failed: %w", op, err)
+	}
+	return string(out), nil
+}
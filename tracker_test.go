@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestJiraURLs(t *testing.T) {
+	old := jiraUrl
+	t.Cleanup(func() { jiraUrl = old })
+	tests := []struct {
+		jiraURL    string
+		wantBase   string
+		wantAPI    string
+		wantBrowse string
+	}{
+		{"https://example.atlassian.net", "https://example.atlassian.net", "https://example.atlassian.net/", "https://example.atlassian.net/browse/ABC-1"},
+		{"https://example.atlassian.net/", "https://example.atlassian.net", "https://example.atlassian.net/", "https://example.atlassian.net/browse/ABC-1"},
+		{"https://example.atlassian.net///", "https://example.atlassian.net", "https://example.atlassian.net/", "https://example.atlassian.net/browse/ABC-1"},
+		{"example.atlassian.net", "https://example.atlassian.net", "https://example.atlassian.net/", "https://example.atlassian.net/browse/ABC-1"},
+		{"example.atlassian.net/", "https://example.atlassian.net", "https://example.atlassian.net/", "https://example.atlassian.net/browse/ABC-1"},
+		{"http://jira.internal:8080", "http://jira.internal:8080", "http://jira.internal:8080/", "http://jira.internal:8080/browse/ABC-1"},
+		{"https://example.com/jira", "https://example.com/jira", "https://example.com/jira/", "https://example.com/jira/browse/ABC-1"},
+		{"https://example.com/jira/", "https://example.com/jira", "https://example.com/jira/", "https://example.com/jira/browse/ABC-1"},
+		{"example.com/jira//", "https://example.com/jira", "https://example.com/jira/", "https://example.com/jira/browse/ABC-1"},
+		{"  https://example.atlassian.net/ \n", "https://example.atlassian.net", "https://example.atlassian.net/", "https://example.atlassian.net/browse/ABC-1"},
+		{" example.com/jira ", "https://example.com/jira", "https://example.com/jira/", "https://example.com/jira/browse/ABC-1"},
+	}
+	for _, tt := range tests {
+		jiraUrl = tt.jiraURL
+		if got := jiraBaseURL(); got != tt.wantBase {
+			t.Errorf("JIRA_URL %q: jiraBaseURL() = %q, want %q", tt.jiraURL, got, tt.wantBase)
+		}
+		if got := jiraAPIBase(); got != tt.wantAPI {
+			t.Errorf("JIRA_URL %q: jiraAPIBase() = %q, want %q", tt.jiraURL, got, tt.wantAPI)
+		}
+		if got := jiraBrowseURL("ABC-1"); got != tt.wantBrowse {
+			t.Errorf("JIRA_URL %q: jiraBrowseURL() = %q, want %q", tt.jiraURL, got, tt.wantBrowse)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// startUpdateCheck looks for a newer release in the background. Call the returned function at the end
+// of the run to print the result; it waits at most a second, and any failure is silently ignored.
+func startUpdateCheck() func() {
+	result := make(chan string, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		release, _, err := github.NewClient(nil).Repositories.GetLatestRelease(ctx, "reillywatson", "autopr")
+		if err != nil || !newerVersion(release.GetTagName(), version) {
+			result <- ""
+			return
+		}
+		result <- fmt.Sprintf("autopr %s is available (you have %s): %s", release.GetTagName(), version, release.GetHTMLURL())
+	}()
+	return func() {
+		select {
+		case msg := <-result:
+			if msg != "" {
+				fmt.Fprintln(os.Stderr, msg)
+			}
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+var describeSuffix = regexp.MustCompile(`-\d+-g[0-9a-f]+(-dirty)?$`)
+
+// newerVersion says whether release is a later version than current, comparing them as semantic versions.
+// Builds from git describe (e.g. v1.2.3-4-gabc1234) count as later than the tag they're based on. If either
+// doesn't parse (e.g. "dev") the answer is no, since telling someone to downgrade is worse than saying nothing.
+func newerVersion(release string, current string) bool {
+	rCore, rPre, ok := parseVersion(release)
+	if !ok {
+		return false
+	}
+	described := describeSuffix.MatchString(current)
+	cCore, cPre, ok := parseVersion(describeSuffix.ReplaceAllString(current, ""))
+	if !ok {
+		return false
+	}
+	for i := range rCore {
+		if rCore[i] != cCore[i] {
+			return rCore[i] > cCore[i]
+		}
+	}
+	switch {
+	case described:
+		return false
+	case rPre == "" || cPre == "":
+		// a release is later than its pre-releases
+		return rPre == "" && cPre != ""
+	}
+	return comparePrerelease(rPre, cPre) > 0
+}
+
+// parseVersion splits a version like v1.2.3-rc.1+build into its numbers and pre-release part.
+// Missing minor and patch numbers are taken as 0.
+func parseVersion(v string) ([3]int, string, bool) {
+	var core [3]int
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "+")
+	v, pre, _ := strings.Cut(v, "-")
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return core, "", false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return core, "", false
+		}
+		core[i] = n
+	}
+	return core, pre, true
+}
+
+// comparePrerelease orders pre-release versions the way semver does: dot-separated parts in turn, numbers
+// numerically and before words, and a shorter list first if it's a prefix of the other.
+func comparePrerelease(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return an - bn
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(as) - len(bs)
+}
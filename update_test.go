@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		release string
+		current string
+		want    bool
+	}{
+		{"v1.2.4", "v1.2.3", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v2.0.0", "v1.99.99", true},
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.2.3", "v1.2.4", false},
+		{"v1.2.3", "v1.3.0", false},
+		{"v1.2", "v1.2.0", false},
+		{"v1.2.3", "v1.2.3-rc.1", true},
+		{"v1.2.3-rc.1", "v1.2.3", false},
+		{"v1.2.3-rc.2", "v1.2.3-rc.1", true},
+		{"v1.2.3-rc.10", "v1.2.3-rc.9", true},
+		{"v1.2.3-rc.1", "v1.2.3-beta", true},
+		{"v1.2.3-rc.1.1", "v1.2.3-rc.1", true},
+		{"v1.2.3+build.5", "v1.2.3", false},
+		{"v1.2.3", "v1.2.3-4-gabc1234", false},
+		{"v1.2.3", "v1.2.3-4-gabc1234-dirty", false},
+		{"v1.2.4", "v1.2.3-4-gabc1234", true},
+		{"v1.2.3", "dev", false},
+		{"nightly", "v1.2.3", false},
+		{"", "v1.2.3", false},
+	}
+	for _, tt := range tests {
+		if got := newerVersion(tt.release, tt.current); got != tt.want {
+			t.Errorf("newerVersion(%q, %q) = %v, want %v", tt.release, tt.current, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validateConfigFile checks a config file without needing tokens or the network, and returns every problem
+// it finds. Keys must be environment setting or flag names, values must parse as what they're used as, and
+// the settings every run needs must be set either in the file or somewhere else (the environment or the
+// other config file).
+func validateConfigFile(path string) []string {
+	// readConfigFile treats a missing file as empty, which is right for loading but not here
+	if _, err := os.Stat(path); err != nil {
+		return []string{err.Error()}
+	}
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	problemf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	keys := make([]string, 0, len(cfg.Settings))
+	for k := range cfg.Settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := cfg.Settings[k]
+		if f := flag.Lookup(k); f != nil {
+			// we exit after validating, so it doesn't matter that this sets the flag
+			if err := f.Value.Set(v); err != nil {
+				problemf("%s: %v", k, err)
+			} else if allowed, ok := flagEnums()[k]; ok && !containsString(allowed, v) {
+				problemf("%s: %q must be one of %s", k, v, strings.Join(allowed, ", "))
+			}
+			continue
+		}
+		if !envSettings[k] {
+			problemf("%s: not a known setting or flag", k)
+			continue
+		}
+		if secretSettings[k] && !sameFile(path, userConfigPath()) {
+			problemf("%s: secrets are only read from the environment or %s, not a config file that may be committed", k, userConfigPath())
+			continue
+		}
+		if check, ok := envChecks[k]; ok {
+			if err := check(v); err != nil {
+				problemf("%s: %v", k, err)
+			}
+		}
+	}
+	tracker := *trackerName
+	if v, ok := cfg.Settings["tracker"]; ok {
+		tracker = v
+	}
+	required := []string{"TARGET_GITHUB_ORG", "TARGET_GITHUB_REPO", "SOURCE_GITHUB_ORG"}
+	switch tracker {
+	case "jira":
+		required = append(required, "JIRA_URL", "JIRA_USER_NAME", "JIRA_PROJECT_NAME")
+	case "custom":
+		required = append(required, "TRACKER_CMD")
+	}
+	for _, k := range required {
+		if cfg.Settings[k] == "" && getenv(k) == "" {
+			problemf("%s: required, and not set here or anywhere else", k)
+		}
+	}
+	return problems
+}
+
+func sameFile(a string, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+// flagEnums lists the allowed values of flags that only take a few.
+func flagEnums() map[string][]string {
+	return map[string][]string{
+		"bodyMode":          {"commit", "titleOnly", "template", "file"},
+		"dirtyMode":         {"tracked", "all", "off"},
+		"emptyBodyBehavior": {"blank", "copyTitle", "template", "prompt"},
+		"prTitleStyle":      {"plain", "type", "scope", "typeScope"},
+		"tracker":           trackerNames(),
+	}
+}
+
+var envChecks = map[string]func(string) error{
+	"JIRA_BOARD_ID": func(v string) error {
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Errorf("%q isn't a number", v)
+		}
+		return nil
+	},
+	"JIRA_ISSUE_TYPE": func(v string) error {
+		if strings.TrimSpace(v) == "" {
+			return fmt.Errorf("must not be empty")
+		}
+		return nil
+	},
+	"JIRA_URL": func(v string) error {
+		// checked the way it'll be used, so e.g. a missing https:// is fine
+		return checkURL(normalizeJiraURL(v))
+	},
+	"GITHUB_BASE_URL":         checkURL,
+	"GITHUB_UPLOAD_URL":       checkURL,
+	"JIRA_AREA_MAP":           checkPairs,
+	"JIRA_BRANCH_ISSUE_TYPES": checkPairs,
+	"JIRA_STATUS_ALIASES":     checkPairs,
+	"JIRA_SYNC_STATUS_MAP": func(v string) error {
+		if err := checkPairs(v); err != nil {
+			return err
+		}
+		for state := range parseKeyValueList(v) {
+			if state != "open" && state != "merged" && state != "closed" {
+				return fmt.Errorf("%q isn't a PR state, must be open, merged or closed", state)
+			}
+		}
+		return nil
+	},
+}
+
+func checkURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q isn't an http(s) URL", v)
+	}
+	return nil
+}
+
+// checkPairs checks a "key=value,key=value" list.
+func checkPairs(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		if k, _, ok := strings.Cut(pair, "="); !ok || strings.TrimSpace(k) == "" {
+			return fmt.Errorf("%q isn't key=value", pair)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigFileJiraURL(t *testing.T) {
+	tests := []struct {
+		jiraURL string
+		wantOK  bool
+	}{
+		{"https://example.atlassian.net", true},
+		{"example.atlassian.net", true},
+		{"example.com/jira/", true},
+		{" https://example.atlassian.net/ ", true},
+		{"ftp://example.com", false},
+		{"https://bad host", false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		if err := os.WriteFile(path, []byte("JIRA_URL: \""+tt.jiraURL+"\"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var urlProblems []string
+		for _, p := range validateConfigFile(path) {
+			if strings.HasPrefix(p, "JIRA_URL:") && !strings.Contains(p, "required") {
+				urlProblems = append(urlProblems, p)
+			}
+		}
+		if ok := len(urlProblems) == 0; ok != tt.wantOK {
+			t.Errorf("JIRA_URL %q: problems %q, want ok %v", tt.jiraURL, urlProblems, tt.wantOK)
+		}
+	}
+}